@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// compressionStats aggregates the compression efficiency of a group of chunks.
+type compressionStats struct {
+	name       string
+	chunks     int
+	samples    int
+	bytes      int
+	undersized int
+	// Bytes per sample of every chunk, sorted before reporting.
+	ratios []float64
+}
+
+func (s *compressionStats) add(samples, bytes int, undersized bool) {
+	s.chunks++
+	s.samples += samples
+	s.bytes += bytes
+	if undersized {
+		s.undersized++
+	}
+	if samples > 0 {
+		s.ratios = append(s.ratios, float64(bytes)/float64(samples))
+	}
+}
+
+// quantile returns the q-quantile of the bytes per sample using the
+// nearest-rank method. The ratios must be sorted.
+func (s *compressionStats) quantile(q float64) float64 {
+	if len(s.ratios) == 0 {
+		return 0
+	}
+	i := int(q*float64(len(s.ratios))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s.ratios) {
+		i = len(s.ratios) - 1
+	}
+	return s.ratios[i]
+}
+
+func (s *compressionStats) samplesPerChunk() float64 {
+	if s.chunks == 0 {
+		return 0
+	}
+	return float64(s.samples) / float64(s.chunks)
+}
+
+func (s *compressionStats) undersizedRatio() float64 {
+	if s.chunks == 0 {
+		return 0
+	}
+	return float64(s.undersized) / float64(s.chunks)
+}
+
+// metricType guesses the type of a metric from the naming conventions since
+// the TSDB doesn't store metadata.
+func metricType(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_total"):
+		return "counter"
+	case strings.HasSuffix(name, "_bucket"):
+		return "histogram"
+	case strings.HasSuffix(name, "_sum"), strings.HasSuffix(name, "_count"):
+		return "summary/histogram"
+	}
+	return "other"
+}
+
+// compressionGroups maintains the stats of groups keyed by name in insertion
+// order.
+type compressionGroups struct {
+	m    map[string]*compressionStats
+	list []*compressionStats
+}
+
+func newCompressionGroups() *compressionGroups {
+	return &compressionGroups{m: make(map[string]*compressionStats)}
+}
+
+func (g *compressionGroups) get(name string) *compressionStats {
+	s, ok := g.m[name]
+	if !ok {
+		s = &compressionStats{name: name}
+		g.m[name] = s
+		g.list = append(g.list, s)
+	}
+	return s
+}
+
+var compressionSortKeys = map[string]func(a, b *compressionStats) bool{
+	"chunks":     func(a, b *compressionStats) bool { return a.chunks > b.chunks },
+	"bytes":      func(a, b *compressionStats) bool { return a.bytes > b.bytes },
+	"p50":        func(a, b *compressionStats) bool { return a.quantile(0.5) > b.quantile(0.5) },
+	"p99":        func(a, b *compressionStats) bool { return a.quantile(0.99) > b.quantile(0.99) },
+	"undersized": func(a, b *compressionStats) bool { return a.undersizedRatio() > b.undersizedRatio() },
+}
+
+func runCompression(args []string) error {
+	var (
+		sortBy     string
+		limit      int
+		minSamples int
+	)
+	fs := newFlagSet("compression", "Reports the compression efficiency of the chunks per encoding, metric type and metric name.")
+	fs.StringVar(&sortBy, "sort", "bytes", "Sort key for metrics (chunks, bytes, p50, p99 or undersized)")
+	fs.IntVar(&limit, "limit", 20, "Number of metrics to show (0 for all)")
+	fs.IntVar(&minSamples, "min-samples", 60, "Chunks with fewer samples are considered undersized (full chunks hold 120 samples)")
+	match := addMatchFlag(fs)
+	dir, err := blockDir(fs, args)
+	if err != nil {
+		return err
+	}
+	ms, err := parseMatchers(*match)
+	if err != nil {
+		return err
+	}
+	less, ok := compressionSortKeys[sortBy]
+	if !ok {
+		return errors.Errorf("invalid --sort value %q", sortBy)
+	}
+
+	b, err := openBlock(dir)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	p, err := b.postings(ms)
+	if err != nil {
+		return err
+	}
+
+	var (
+		encodings = newCompressionGroups()
+		types     = newCompressionGroups()
+		metrics   = newCompressionGroups()
+	)
+	err = b.forEachSeries(p, func(_ uint64, lbls labels.Labels, chks []chunks.Meta) error {
+		name := lbls.Get(labels.MetricName)
+		for _, chkMeta := range chks {
+			chunk, err := b.chunks.Chunk(chkMeta.Ref)
+			if err != nil {
+				return errors.Wrapf(err, "chunk %d of series %s", chkMeta.Ref, lbls)
+			}
+			var (
+				enc        = chunk.Encoding()
+				n          = chunk.NumSamples()
+				sz         = len(chunk.Bytes())
+				undersized = n < minSamples
+			)
+			encodings.get(enc.String()).add(n, sz, undersized)
+			types.get(groupName(enc, metricType(name))).add(n, sz, undersized)
+			metrics.get(groupName(enc, name)).add(n, sz, undersized)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, g := range []*compressionGroups{encodings, types, metrics} {
+		for _, s := range g.list {
+			sort.Float64s(s.ratios)
+		}
+	}
+	sort.SliceStable(metrics.list, func(i, j int) bool { return less(metrics.list[i], metrics.list[j]) })
+	if limit > 0 && len(metrics.list) > limit {
+		metrics.list = metrics.list[:limit]
+	}
+
+	fmt.Println("By encoding:")
+	printCompressionStats(os.Stdout, "ENCODING", encodings.list)
+	fmt.Println("\nBy encoding and metric type:")
+	printCompressionStats(os.Stdout, "ENCODING/TYPE", types.list)
+	fmt.Println("\nBy encoding and metric name:")
+	printCompressionStats(os.Stdout, "ENCODING/METRIC", metrics.list)
+	return nil
+}
+
+func groupName(enc chunkenc.Encoding, name string) string {
+	return enc.String() + "/" + name
+}
+
+func printCompressionStats(w io.Writer, header string, stats []*compressionStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintf(tw, "%s\tCHUNKS\tBYTES\tSAMPLES/CHUNK\tP50 B/S\tP90 B/S\tP99 B/S\tUNDERSIZED\n", header)
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.1f%%\n",
+			s.name, s.chunks, s.bytes, s.samplesPerChunk(),
+			s.quantile(0.5), s.quantile(0.9), s.quantile(0.99), 100*s.undersizedRatio())
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+// sampleWriter writes the samples of a series in a given format.
+type sampleWriter interface {
+	write(lbls labels.Labels, samples []sample) error
+	flush() error
+}
+
+type textWriter struct {
+	w *bufio.Writer
+}
+
+func (tw *textWriter) write(lbls labels.Labels, samples []sample) error {
+	fmt.Fprintf(tw.w, "series: %s\n", lbls)
+	fmt.Fprintf(tw.w, "samples:\n")
+	for _, s := range samples {
+		fmt.Fprintf(tw.w, "\t%d\t%s\t%s\n", s.t, time.Unix(s.t/1e3, (s.t%1e3)*1e6).UTC().Format(time.RFC3339Nano), formatValue(s.v))
+	}
+	return nil
+}
+
+func (tw *textWriter) flush() error { return tw.w.Flush() }
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvWriter) write(lbls labels.Labels, samples []sample) error {
+	series := lbls.String()
+	for _, s := range samples {
+		if err := cw.w.Write([]string{series, strconv.FormatInt(s.t, 10), formatValue(s.v)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cw *csvWriter) flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonWriter writes one JSON object per series. Values are encoded as strings
+// like in the Prometheus HTTP API because JSON can't represent NaN or Inf.
+type jsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+type jsonSeries struct {
+	Labels  labels.Labels    `json:"labels"`
+	Samples [][2]interface{} `json:"samples"`
+}
+
+func (jw *jsonWriter) write(lbls labels.Labels, samples []sample) error {
+	s := jsonSeries{Labels: lbls, Samples: make([][2]interface{}, 0, len(samples))}
+	for _, smpl := range samples {
+		s.Samples = append(s.Samples, [2]interface{}{smpl.t, formatValue(smpl.v)})
+	}
+	return jw.enc.Encode(s)
+}
+
+func (jw *jsonWriter) flush() error { return jw.w.Flush() }
+
+func newSampleWriter(w io.Writer, format string) (sampleWriter, error) {
+	switch format {
+	case "text":
+		return &textWriter{w: bufio.NewWriter(w)}, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"series", "timestamp", "value"}); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case "json":
+		bw := bufio.NewWriter(w)
+		return &jsonWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	}
+	return nil, errors.Errorf("invalid format %q", format)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// parseTime parses either a RFC3339 date or a timestamp in milliseconds.
+func parseTime(s string, def int64) (int64, error) {
+	if s == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	t, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid time %q: expecting RFC3339 or milliseconds", s)
+	}
+	return t, nil
+}
+
+func runDump(args []string) error {
+	var start, end, format string
+	fs := newFlagSet("dump", "Dumps the raw samples of the block's series.")
+	fs.StringVar(&start, "min-time", "", "Dump samples at or after this time (RFC3339 or milliseconds)")
+	fs.StringVar(&end, "max-time", "", "Dump samples at or before this time (RFC3339 or milliseconds)")
+	fs.StringVar(&format, "format", "text", "Output format (text, csv or json)")
+	match := addMatchFlag(fs)
+	dir, err := blockDir(fs, args)
+	if err != nil {
+		return err
+	}
+	ms, err := parseMatchers(*match)
+	if err != nil {
+		return err
+	}
+	mint, err := parseTime(start, math.MinInt64)
+	if err != nil {
+		return err
+	}
+	maxt, err := parseTime(end, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	sw, err := newSampleWriter(os.Stdout, format)
+	if err != nil {
+		return err
+	}
+
+	b, err := openBlock(dir)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	p, err := b.postings(ms)
+	if err != nil {
+		return err
+	}
+
+	var samples []sample
+	err = b.forEachSeries(p, func(_ uint64, lbls labels.Labels, chks []chunks.Meta) error {
+		samples = samples[:0]
+		for _, chkMeta := range chks {
+			if !chkMeta.OverlapsClosedInterval(mint, maxt) {
+				continue
+			}
+			chunk, err := b.chunks.Chunk(chkMeta.Ref)
+			if err != nil {
+				return errors.Wrapf(err, "chunk %d of series %s", chkMeta.Ref, lbls)
+			}
+			it := chunk.Iterator(nil)
+			for it.Next() {
+				t, v := it.At()
+				if t < mint || t > maxt {
+					continue
+				}
+				samples = append(samples, sample{t: t, v: v})
+			}
+			if err := it.Err(); err != nil {
+				return errors.Wrapf(err, "chunk %d of series %s", chkMeta.Ref, lbls)
+			}
+		}
+		if len(samples) == 0 {
+			return nil
+		}
+		return sw.write(lbls, samples)
+	})
+	if ferr := sw.flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
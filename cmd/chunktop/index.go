@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/index"
+)
+
+// postingEntrySize is the size of a series reference in a postings list.
+const postingEntrySize = 4
+
+type labelNameStats struct {
+	name        string
+	values      int
+	valuesBytes int
+	postings    int
+}
+
+// indexBytes estimates how much the label name contributes to the index: the
+// symbols of its values plus the entries of its postings lists.
+func (s labelNameStats) indexBytes() int {
+	return len(s.name) + s.valuesBytes + s.postings*postingEntrySize
+}
+
+type postingsStats struct {
+	label  labels.Label
+	series int
+}
+
+func runIndex(args []string) error {
+	var limit int
+	fs := newFlagSet("index", "Reports the structure of the block's index: symbols, label cardinality and postings sizes.")
+	fs.IntVar(&limit, "limit", 20, "Number of entries to show per section (0 for all)")
+	dir, err := blockDir(fs, args)
+	if err != nil {
+		return err
+	}
+
+	b, err := openBlock(dir)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	var symbols, symbolsBytes int
+	it := b.index.Symbols()
+	for it.Next() {
+		symbols++
+		symbolsBytes += len(it.At())
+	}
+	if it.Err() != nil {
+		return errors.Wrap(it.Err(), "symbols")
+	}
+
+	all, err := b.postings(nil)
+	if err != nil {
+		return err
+	}
+	series, err := countPostings(all)
+	if err != nil {
+		return err
+	}
+
+	names, err := b.index.LabelNames()
+	if err != nil {
+		return errors.Wrap(err, "label names")
+	}
+
+	var (
+		nameStats     = make([]labelNameStats, 0, len(names))
+		postingsSizes []postingsStats
+	)
+	for _, name := range names {
+		values, err := b.index.LabelValues(name)
+		if err != nil {
+			return errors.Wrapf(err, "label values for %q", name)
+		}
+		s := labelNameStats{name: name, values: len(values)}
+		for _, value := range values {
+			s.valuesBytes += len(value)
+			p, err := b.index.Postings(name, value)
+			if err != nil {
+				return errors.Wrapf(err, "postings for %s=%q", name, value)
+			}
+			n, err := countPostings(p)
+			if err != nil {
+				return errors.Wrapf(err, "postings for %s=%q", name, value)
+			}
+			s.postings += n
+			postingsSizes = append(postingsSizes, postingsStats{label: labels.Label{Name: name, Value: value}, series: n})
+		}
+		nameStats = append(nameStats, s)
+	}
+
+	toc, err := readTOC(filepath.Join(dir, "index"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Block %s\n\n", dir)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Index size:\t%d bytes\n", b.index.Size())
+	fmt.Fprintf(tw, "Symbol table size:\t%d bytes (%d bytes in memory)\n", toc.Series-toc.Symbols, b.index.SymbolTableSize())
+	fmt.Fprintf(tw, "Series section size:\t%d bytes\n", toc.LabelIndices-toc.Series)
+	fmt.Fprintf(tw, "Label indices size:\t%d bytes\n", toc.Postings-toc.LabelIndices)
+	fmt.Fprintf(tw, "Postings size:\t%d bytes\n", toc.LabelIndicesTable-toc.Postings)
+	fmt.Fprintf(tw, "Symbols:\t%d (%d bytes)\n", symbols, symbolsBytes)
+	fmt.Fprintf(tw, "Series:\t%d\n", series)
+	fmt.Fprintf(tw, "Label names:\t%d\n", len(names))
+	fmt.Fprintf(tw, "Label pairs:\t%d\n", len(postingsSizes))
+	tw.Flush()
+
+	sort.Slice(nameStats, func(i, j int) bool { return nameStats[i].values > nameStats[j].values })
+	fmt.Println("\nLabel names by number of values:")
+	printLabelNameStats(nameStats, limit)
+
+	sort.Slice(nameStats, func(i, j int) bool { return nameStats[i].indexBytes() > nameStats[j].indexBytes() })
+	fmt.Println("\nLabel names by estimated contribution to index size:")
+	printLabelNameStats(nameStats, limit)
+
+	sort.Slice(postingsSizes, func(i, j int) bool { return postingsSizes[i].series > postingsSizes[j].series })
+	if limit > 0 && len(postingsSizes) > limit {
+		postingsSizes = postingsSizes[:limit]
+	}
+	fmt.Println("\nBiggest postings lists:")
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL\tSERIES\tBYTES")
+	for _, p := range postingsSizes {
+		fmt.Fprintf(tw, "%s=%q\t%d\t%d\n", p.label.Name, p.label.Value, p.series, p.series*postingEntrySize)
+	}
+	return tw.Flush()
+}
+
+func printLabelNameStats(stats []labelNameStats, limit int) {
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "NAME\tVALUES\tVALUES BYTES\tPOSTINGS\tINDEX BYTES")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", s.name, s.values, s.valuesBytes, s.postings, s.indexBytes())
+	}
+}
+
+func countPostings(p index.Postings) (int, error) {
+	var n int
+	for p.Next() {
+		n++
+	}
+	return n, errors.Wrap(p.Err(), "postings next")
+}
+
+// fileByteSlice implements index.ByteSlice on top of a file without loading
+// it in memory.
+type fileByteSlice struct {
+	f    *os.File
+	size int
+	err  error
+}
+
+func (b *fileByteSlice) Len() int { return b.size }
+
+func (b *fileByteSlice) Range(start, end int) []byte {
+	buf := make([]byte, end-start)
+	if _, err := b.f.ReadAt(buf, int64(start)); err != nil && b.err == nil {
+		b.err = err
+	}
+	return buf
+}
+
+// readTOC returns the table of contents of the index file which gives the
+// offsets of the index sections.
+func readTOC(fn string) (*index.TOC, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening index")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "opening index")
+	}
+
+	bs := &fileByteSlice{f: f, size: int(fi.Size())}
+	toc, err := index.NewTOCFromByteSlice(bs)
+	if bs.err != nil {
+		err = bs.err
+	}
+	return toc, errors.Wrap(err, "reading index TOC")
+}
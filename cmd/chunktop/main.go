@@ -1,55 +1,211 @@
+// Utility program to inspect the chunks and index of a TSDB block.
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunks"
 	"github.com/prometheus/prometheus/tsdb/index"
 )
 
+type command struct {
+	help string
+	run  func(args []string) error
+}
+
+var commands = map[string]command{
+	"compression": {"Report the compression efficiency per encoding and metric", runCompression},
+	"dump":        {"Dump the raw samples of the series", runDump},
+	"index":       {"Report symbols, label cardinality and postings sizes of the index", runIndex},
+	"list":        {"List all series and chunks of the block", runList},
+	"top":         {"Rank series or metrics by chunks, samples or bytes", runTop},
+	"verify":      {"Verify the integrity of the chunks and their samples", runVerify},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: chunktop <command> [flags] <block dir>")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'chunktop <command> --help' for the command's flags.")
+}
+
 func main() {
-	idx, err := index.NewFileReader("data/01EAHCER7GQQA2S9320PHY58DD/index")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	switch os.Args[1] {
+	case "help", "-help", "--help", "-h":
+		usage()
+		os.Exit(0)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n", os.Args[1])
+		usage()
+		os.Exit(1)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns a flag set for the given command which expects the
+// block directory as its only positional argument.
+func newFlagSet(name, help string) *flag.FlagSet {
+	fs := flag.NewFlagSet("chunktop "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: chunktop %s [flags] <block dir>\n\n%s\n\n", name, help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// blockDir parses the command line and returns the block directory.
+func blockDir(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", errors.New("expecting the block directory as the only argument")
+	}
+	return fs.Arg(0), nil
+}
+
+// addMatchFlag registers the --match flag which restricts the command to the
+// series matching the given selector.
+func addMatchFlag(fs *flag.FlagSet) *string {
+	return fs.String("match", "", "Series selector, e.g. '{job=\"prometheus\"}' (default: all series)")
+}
+
+func parseMatchers(s string) ([]*labels.Matcher, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ms, err := promql.ParseMetricSelector(s)
 	if err != nil {
-		fmt.Println("index:", err)
-		return
+		return nil, errors.Wrapf(err, "invalid series selector %q", s)
 	}
-	chunkReader, err := chunks.NewDirReader("data/01EAHCER7GQQA2S9320PHY58DD/chunks", nil)
+	return ms, nil
+}
+
+type block struct {
+	dir    string
+	index  *index.Reader
+	chunks *chunks.Reader
+}
+
+func openBlock(dir string) (*block, error) {
+	idx, err := index.NewFileReader(filepath.Join(dir, "index"))
 	if err != nil {
-		fmt.Println("chunks:", err)
-		return
+		return nil, errors.Wrap(err, "opening index")
+	}
+	chunkReader, err := chunks.NewDirReader(filepath.Join(dir, "chunks"), nil)
+	if err != nil {
+		idx.Close()
+		return nil, errors.Wrap(err, "opening chunks")
+	}
+	return &block{dir: dir, index: idx, chunks: chunkReader}, nil
+}
+
+func (b *block) Close() error {
+	err := b.chunks.Close()
+	if ierr := b.index.Close(); err == nil {
+		err = ierr
 	}
-	defer chunkReader.Close()
+	return err
+}
 
-	postings, err := idx.Postings("", "")
+// postings returns the postings of the series matching all the matchers or
+// of all the series if no matcher is given. The matchers are resolved against
+// the index the same way as the TSDB querier does.
+func (b *block) postings(ms []*labels.Matcher) (index.Postings, error) {
+	var (
+		p   index.Postings
+		err error
+	)
+	if len(ms) == 0 {
+		p, err = b.index.Postings(index.AllPostingsKey())
+	} else {
+		p, err = tsdb.PostingsForMatchers(b.index, ms...)
+	}
 	if err != nil {
-		fmt.Println("postings:", err)
-		return
+		return nil, errors.Wrap(err, "postings")
 	}
-	for postings.Next() {
-		if err := postings.Err(); err != nil {
-			fmt.Println("postings next:", err)
-			return
+	return p, nil
+}
+
+// forEachSeries calls f for every series of the postings list.
+func (b *block) forEachSeries(p index.Postings, f func(id uint64, lbls labels.Labels, chks []chunks.Meta) error) error {
+	var (
+		lbls labels.Labels
+		chks []chunks.Meta
+	)
+	for p.Next() {
+		id := p.At()
+		if err := b.index.Series(id, &lbls, &chks); err != nil {
+			return errors.Wrapf(err, "series %d", id)
 		}
-		id := postings.At()
-		fmt.Printf("series id: %d\n", id)
-
-		lbls := make(labels.Labels, 0)
-		chks := make([]chunks.Meta, 0)
-		err = idx.Series(id, &lbls, &chks)
-		if err := postings.Err(); err != nil {
-			fmt.Printf("series %d: %s\n", id, err)
-			return
+		if err := f(id, lbls, chks); err != nil {
+			return err
 		}
-		fmt.Printf("series %d, labels: %s, chunks: %d\n", id, lbls.String(), len(chks))
+	}
+	return errors.Wrap(p.Err(), "postings next")
+}
+
+func runList(args []string) error {
+	fs := newFlagSet("list", "Lists all series and chunks of the block in postings order.")
+	match := addMatchFlag(fs)
+	dir, err := blockDir(fs, args)
+	if err != nil {
+		return err
+	}
+	ms, err := parseMatchers(*match)
+	if err != nil {
+		return err
+	}
 
+	b, err := openBlock(dir)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	p, err := b.postings(ms)
+	if err != nil {
+		return err
+	}
+	return b.forEachSeries(p, func(id uint64, lbls labels.Labels, chks []chunks.Meta) error {
+		fmt.Printf("series %d, labels: %s, chunks: %d\n", id, lbls.String(), len(chks))
 		for i, chkMeta := range chks {
-			fmt.Printf("chunk %d, ref: %d", i, chkMeta.Ref)
-			chunk, err := chunkReader.Chunk(chkMeta.Ref)
+			chunk, err := b.chunks.Chunk(chkMeta.Ref)
 			if err != nil {
-				fmt.Printf("chunk: %s\n", err)
-				return
+				return errors.Wrapf(err, "chunk %d of series %s", chkMeta.Ref, lbls)
 			}
-			fmt.Printf(", encoding: %s, size: %d, bytes: %d\n", chunk.Encoding(), chunk.NumSamples(), len(chunk.Bytes()))
+			fmt.Printf("chunk %d, ref: %d, encoding: %s, samples: %d, bytes: %d\n", i, chkMeta.Ref, chunk.Encoding(), chunk.NumSamples(), len(chunk.Bytes()))
 		}
-	}
+		return nil
+	})
 }
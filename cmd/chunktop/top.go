@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// chunkStats aggregates the size of a set of chunks.
+type chunkStats struct {
+	series  int
+	chunks  int
+	samples int
+	bytes   int
+}
+
+func (s *chunkStats) add(o chunkStats) {
+	s.series += o.series
+	s.chunks += o.chunks
+	s.samples += o.samples
+	s.bytes += o.bytes
+}
+
+func (s chunkStats) bytesPerSample() float64 {
+	if s.samples == 0 {
+		return 0
+	}
+	return float64(s.bytes) / float64(s.samples)
+}
+
+type topEntry struct {
+	name string
+	chunkStats
+}
+
+// sortKeys maps the accepted --sort values to their comparison functions.
+var sortKeys = map[string]func(a, b *topEntry) bool{
+	"chunks":           func(a, b *topEntry) bool { return a.chunks > b.chunks },
+	"samples":          func(a, b *topEntry) bool { return a.samples > b.samples },
+	"bytes":            func(a, b *topEntry) bool { return a.bytes > b.bytes },
+	"bytes-per-sample": func(a, b *topEntry) bool { return a.bytesPerSample() > b.bytesPerSample() },
+	"series":           func(a, b *topEntry) bool { return a.series > b.series },
+}
+
+func runTop(args []string) error {
+	var (
+		by, sortBy string
+		limit      int
+	)
+	fs := newFlagSet("top", "Ranks the series or metrics of the block by chunks, samples or bytes.")
+	fs.StringVar(&by, "by", "metric", "Aggregation level (metric or series)")
+	fs.StringVar(&sortBy, "sort", "bytes", "Sort key (chunks, samples, bytes, bytes-per-sample or series)")
+	fs.IntVar(&limit, "limit", 20, "Number of entries to show (0 for all)")
+	match := addMatchFlag(fs)
+	dir, err := blockDir(fs, args)
+	if err != nil {
+		return err
+	}
+	ms, err := parseMatchers(*match)
+	if err != nil {
+		return err
+	}
+	if by != "metric" && by != "series" {
+		return errors.Errorf("invalid --by value %q", by)
+	}
+	less, ok := sortKeys[sortBy]
+	if !ok {
+		return errors.Errorf("invalid --sort value %q", sortBy)
+	}
+
+	b, err := openBlock(dir)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	p, err := b.postings(ms)
+	if err != nil {
+		return err
+	}
+
+	var (
+		total   chunkStats
+		entries []*topEntry
+		metrics = make(map[string]*topEntry)
+	)
+	err = b.forEachSeries(p, func(_ uint64, lbls labels.Labels, chks []chunks.Meta) error {
+		s := chunkStats{series: 1}
+		for _, chkMeta := range chks {
+			chunk, err := b.chunks.Chunk(chkMeta.Ref)
+			if err != nil {
+				return errors.Wrapf(err, "chunk %d of series %s", chkMeta.Ref, lbls)
+			}
+			s.chunks++
+			s.samples += chunk.NumSamples()
+			s.bytes += len(chunk.Bytes())
+		}
+		total.add(s)
+
+		if by == "series" {
+			entries = append(entries, &topEntry{name: lbls.String(), chunkStats: s})
+			return nil
+		}
+		name := lbls.Get(labels.MetricName)
+		e, ok := metrics[name]
+		if !ok {
+			e = &topEntry{name: name}
+			metrics[name] = e
+			entries = append(entries, e)
+		}
+		e.add(s)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	fmt.Printf("Block %s: %d series, %d chunks, %d samples, %d bytes (%.2f bytes/sample)\n\n",
+		dir, total.series, total.chunks, total.samples, total.bytes, total.bytesPerSample())
+	printTop(os.Stdout, by, entries)
+	return nil
+}
+
+func printTop(w io.Writer, by string, entries []*topEntry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	if by == "series" {
+		fmt.Fprintln(tw, "SERIES\tCHUNKS\tSAMPLES\tBYTES\tBYTES/SAMPLE")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f\n", e.name, e.chunks, e.samples, e.bytes, e.bytesPerSample())
+		}
+		return
+	}
+
+	fmt.Fprintln(tw, "METRIC\tSERIES\tCHUNKS\tSAMPLES\tBYTES\tBYTES/SAMPLE")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.2f\n", e.name, e.series, e.chunks, e.samples, e.bytes, e.bytesPerSample())
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+type verifyStats struct {
+	series, chunks, samples int
+	nan, stale              int
+	errors, warnings        int
+}
+
+func runVerify(args []string) error {
+	var quiet bool
+	fs := newFlagSet("verify", "Verifies the integrity of the block's chunks by decoding all samples.")
+	fs.BoolVar(&quiet, "quiet", false, "Don't report NaN values and stale markers")
+	match := addMatchFlag(fs)
+	dir, err := blockDir(fs, args)
+	if err != nil {
+		return err
+	}
+	ms, err := parseMatchers(*match)
+	if err != nil {
+		return err
+	}
+
+	b, err := openBlock(dir)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	p, err := b.postings(ms)
+	if err != nil {
+		return err
+	}
+
+	var st verifyStats
+	err = b.forEachSeries(p, func(_ uint64, lbls labels.Labels, chks []chunks.Meta) error {
+		st.series++
+		reportf := func(i int, format string, args ...interface{}) {
+			st.errors++
+			fmt.Printf("ERROR %s chunk %d (ref: %d): %s\n", lbls, i, chks[i].Ref, fmt.Sprintf(format, args...))
+		}
+
+		var nan, stale int
+		for i, chkMeta := range chks {
+			st.chunks++
+			if chkMeta.MinTime > chkMeta.MaxTime {
+				reportf(i, "min time %d after max time %d", chkMeta.MinTime, chkMeta.MaxTime)
+			}
+			if i > 0 && chkMeta.MinTime <= chks[i-1].MaxTime {
+				reportf(i, "min time %d overlaps with previous chunk ending at %d", chkMeta.MinTime, chks[i-1].MaxTime)
+			}
+
+			// The chunk reader checks the CRC32 of the chunk's data.
+			chunk, err := b.chunks.Chunk(chkMeta.Ref)
+			if err != nil {
+				reportf(i, "%s", err)
+				continue
+			}
+
+			var (
+				n    int
+				prev int64
+				it   = chunk.Iterator(nil)
+			)
+			for it.Next() {
+				t, v := it.At()
+				switch {
+				case n > 0 && t <= prev:
+					reportf(i, "sample %d: timestamp %d not after previous timestamp %d", n, t, prev)
+				case t < chkMeta.MinTime || t > chkMeta.MaxTime:
+					reportf(i, "sample %d: timestamp %d outside of chunk range [%d, %d]", n, t, chkMeta.MinTime, chkMeta.MaxTime)
+				}
+				if value.IsStaleNaN(v) {
+					stale++
+				} else if math.IsNaN(v) {
+					nan++
+				}
+				prev = t
+				n++
+			}
+			if err := it.Err(); err != nil {
+				reportf(i, "decoding sample %d: %s", n, err)
+			}
+			if n != chunk.NumSamples() {
+				reportf(i, "decoded %d samples, expected %d", n, chunk.NumSamples())
+			}
+			st.samples += n
+		}
+
+		st.nan += nan
+		st.stale += stale
+		if !quiet && (nan > 0 || stale > 0) {
+			st.warnings++
+			fmt.Printf("WARN %s: %d NaN values, %d stale markers\n", lbls, nan, stale)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Verified %d series, %d chunks, %d samples: %d errors, %d NaN values, %d stale markers\n",
+		st.series, st.chunks, st.samples, st.errors, st.nan, st.stale)
+	if st.errors > 0 {
+		return errors.Errorf("block %s is corrupted", dir)
+	}
+	return nil
+}
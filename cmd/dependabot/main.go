@@ -11,10 +11,12 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/go-github/v27/github"
 	"github.com/pkg/errors"
+	"github.com/simonpasquier/sandbox/pkg/tracing"
 	"golang.org/x/oauth2"
 )
 
@@ -26,6 +28,8 @@ var (
 	ghUser          string
 	ghUpstreamOrg   string
 	updateScript    string
+	traceDir        string
+	traceDuration   time.Duration
 )
 
 const (
@@ -42,6 +46,8 @@ func init() {
 	flag.StringVar(&ghUser, "github.user", "", "Your GitHub user")
 	flag.StringVar(&ghUpstreamOrg, "github.upstream_org", "prometheus", "The upstream organization")
 	flag.StringVar(&updateScript, "script", "", "Script to run on dependabot pull requests")
+	flag.StringVar(&traceDir, "trace.dir", os.TempDir(), "Directory of the Go execution traces captured on SIGUSR1")
+	flag.DurationVar(&traceDuration, "trace.duration", tracing.DefaultDuration, "Duration of the Go execution traces captured on SIGUSR1")
 }
 
 func readTokenFile(name string) string {
@@ -76,6 +82,9 @@ func main() {
 
 	ght := readTokenFile(ghtPath)
 
+	stopTracing := tracing.NotifySignal(traceDir, traceDuration, nil, syscall.SIGUSR1)
+	defer stopTracing()
+
 	ctx := context.Background()
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: ght},
@@ -94,8 +103,9 @@ func main() {
 		repoCh = make(chan *github.Repository)
 		forks  = make([]*github.Repository, 0)
 	)
+	listCtx, endList := tracing.StartTask(ctx, "list forks")
 	for {
-		repos, resp, err := ghc.Repositories.List(ctx, ghUser, opt)
+		repos, resp, err := ghc.Repositories.List(listCtx, ghUser, opt)
 		if err != nil {
 			log.Fatal(errors.Wrapf(err, "failed to list repositories for %s", ghUser))
 		}
@@ -106,7 +116,7 @@ func main() {
 				if !repo.GetFork() {
 					return
 				}
-				repo, _, err := ghc.Repositories.Get(ctx, ghUser, repo.GetName())
+				repo, _, err := ghc.Repositories.Get(listCtx, ghUser, repo.GetName())
 				if err != nil {
 					fmt.Println("✗", errors.Wrapf(err, "failed to get repository %s", repo.GetName()))
 					return
@@ -129,6 +139,7 @@ func main() {
 	for repo := range repoCh {
 		forks = append(forks, repo)
 	}
+	endList()
 	fmt.Printf("✔ Found %d forks\n", len(forks))
 
 	prCh := make(chan *dependabotPullRequest, concurrency)
@@ -137,9 +148,15 @@ func main() {
 		go func() {
 			defer wg.Done()
 			for pr := range prCh {
+				var endTask func()
+				pr.ctx, endTask = tracing.StartTask(pr.ctx, "pull request")
 				s := pr.getState()
 				for {
-					next, err := process(pr, s)
+					var (
+						next string
+						err  error
+					)
+					tracing.WithRegion(pr.ctx, s, func() { next, err = process(pr, s) })
 					if err != nil {
 						fmt.Printf("✗ %s: failed processing %q state: %s\n", pr, s, err)
 						break
@@ -155,6 +172,7 @@ func main() {
 					}
 					s = next
 				}
+				endTask()
 			}
 		}()
 	}
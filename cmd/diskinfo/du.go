@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// dirUsage is the disk usage of a top-level entry of the inspected directory.
+type dirUsage struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Apparent  uint64 `json:"apparent"`
+	Allocated uint64 `json:"allocated"`
+	Files     uint64 `json:"files"`
+	// Time range of the TSDB block, in milliseconds.
+	MinTime *int64 `json:"min_time,omitempty"`
+	MaxTime *int64 `json:"max_time,omitempty"`
+}
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// usageWalker sums the sizes of the files, hard links being counted only
+// once.
+type usageWalker struct {
+	seen map[fileID]struct{}
+}
+
+func (w *usageWalker) add(u *dirUsage, path string) error {
+	return filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		w.addFile(u, fi)
+		return nil
+	})
+}
+
+func (w *usageWalker) addFile(u *dirUsage, fi os.FileInfo) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		id := fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+		if _, ok := w.seen[id]; ok {
+			return
+		}
+		w.seen[id] = struct{}{}
+		// st_blocks is always expressed in 512-byte units.
+		u.Allocated += uint64(st.Blocks) * 512
+	}
+	if !fi.IsDir() {
+		u.Files++
+		u.Apparent += uint64(fi.Size())
+	}
+}
+
+// dirUsages returns the usage of every top-level entry of dir. The entries
+// of a Prometheus data directory are identified: blocks (with their time
+// range), WAL, WAL checkpoints and head chunks.
+func dirUsages(dir string) ([]*dirUsage, error) {
+	w := &usageWalker{seen: make(map[fileID]struct{})}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		usages []*dirUsage
+		files  = &dirUsage{Name: "(files)", Kind: "files"}
+	)
+	for _, fi := range fis {
+		path := filepath.Join(dir, fi.Name())
+		if !fi.IsDir() {
+			if err := w.add(files, path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		u := &dirUsage{Name: fi.Name(), Kind: "directory"}
+		switch {
+		case fi.Name() == "wal":
+			u.Kind = "wal"
+			checkpoints, err := walUsages(w, u, path)
+			if err != nil {
+				return nil, err
+			}
+			usages = append(usages, checkpoints...)
+		case fi.Name() == "chunks_head":
+			u.Kind = "head chunks"
+			err = w.add(u, path)
+		case isULID(fi.Name()):
+			u.Kind = "block"
+			if mint, maxt, err := blockRange(path); err == nil {
+				u.MinTime, u.MaxTime = &mint, &maxt
+			}
+			err = w.add(u, path)
+		case strings.HasSuffix(fi.Name(), ".tmp") && isULID(strings.TrimSuffix(fi.Name(), ".tmp")):
+			u.Kind = "tmp block"
+			err = w.add(u, path)
+		default:
+			err = w.add(u, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+	if files.Files > 0 {
+		usages = append(usages, files)
+	}
+
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Allocated > usages[j].Allocated })
+	return usages, nil
+}
+
+// walUsages adds the usage of the WAL segments to u and returns the usage of
+// the checkpoints.
+func walUsages(w *usageWalker, u *dirUsage, dir string) ([]*dirUsage, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Account for the WAL directory itself like filepath.Walk does for the
+	// other directories.
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	w.addFile(u, fi)
+
+	var checkpoints []*dirUsage
+	for _, fi := range fis {
+		path := filepath.Join(dir, fi.Name())
+		if fi.IsDir() && strings.HasPrefix(fi.Name(), "checkpoint.") {
+			cp := &dirUsage{Name: filepath.Join("wal", fi.Name()), Kind: "checkpoint"}
+			if err := w.add(cp, path); err != nil {
+				return nil, err
+			}
+			checkpoints = append(checkpoints, cp)
+			continue
+		}
+		if err := w.add(u, path); err != nil {
+			return nil, err
+		}
+	}
+	return checkpoints, nil
+}
+
+func isULID(s string) bool {
+	_, err := ulid.ParseStrict(s)
+	return err == nil
+}
+
+func blockRange(dir string) (int64, int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "meta.json"))
+	if err != nil {
+		return 0, 0, err
+	}
+	var meta struct {
+		MinTime int64 `json:"minTime"`
+		MaxTime int64 `json:"maxTime"`
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return 0, 0, err
+	}
+	return meta.MinTime, meta.MaxTime, nil
+}
+
+func msToTime(ms int64) time.Time {
+	return time.Unix(ms/1e3, (ms%1e3)*1e6).UTC()
+}
+
+func printDirUsages(w io.Writer, usages []*dirUsage, f formatter) {
+	var total dirUsage
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "NAME\tKIND\tAPPARENT\tALLOCATED\tFILES\tTIME RANGE")
+	for _, u := range usages {
+		total.Apparent += u.Apparent
+		total.Allocated += u.Allocated
+		total.Files += u.Files
+
+		timeRange := "-"
+		if u.MinTime != nil {
+			mint, maxt := msToTime(*u.MinTime), msToTime(*u.MaxTime)
+			timeRange = fmt.Sprintf("%s - %s (%s)", mint.Format(time.RFC3339), maxt.Format(time.RFC3339), maxt.Sub(mint))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", u.Name, u.Kind, f.bytes(u.Apparent), f.bytes(u.Allocated), u.Files, timeRange)
+	}
+	fmt.Fprintf(tw, "total\t\t%s\t%s\t%d\t\n", f.bytes(total.Apparent), f.bytes(total.Allocated), total.Files)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "diskinfo"
+
+var (
+	labelNames = []string{"path", "fstype"}
+
+	sizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "size_bytes"),
+		"Filesystem size in bytes.",
+		labelNames, nil,
+	)
+	usedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "used_bytes"),
+		"Filesystem used space in bytes.",
+		labelNames, nil,
+	)
+	freeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "free_bytes"),
+		"Filesystem free space in bytes.",
+		labelNames, nil,
+	)
+	availDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "avail_bytes"),
+		"Filesystem space available to non-root users in bytes.",
+		labelNames, nil,
+	)
+	inodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "inodes"),
+		"Filesystem total number of inodes.",
+		labelNames, nil,
+	)
+	inodesUsedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "inodes_used"),
+		"Filesystem number of used inodes.",
+		labelNames, nil,
+	)
+	inodesFreeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "inodes_free"),
+		"Filesystem number of free inodes.",
+		labelNames, nil,
+	)
+	readOnlyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "readonly"),
+		"Whether the filesystem is mounted read-only.",
+		labelNames, nil,
+	)
+	errorDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "error"),
+		"Whether an error occurred while getting the statistics of the path.",
+		[]string{"path"}, nil,
+	)
+)
+
+// diskCollector collects the filesystem statistics of the paths at each
+// scrape.
+type diskCollector struct {
+	paths []string
+}
+
+func (c *diskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sizeDesc
+	ch <- usedDesc
+	ch <- freeDesc
+	ch <- availDesc
+	ch <- inodesDesc
+	ch <- inodesUsedDesc
+	ch <- inodesFreeDesc
+	ch <- readOnlyDesc
+	ch <- errorDesc
+}
+
+func (c *diskCollector) Collect(ch chan<- prometheus.Metric) {
+	// The label falls back to the type from statfs(2) if the mount table
+	// can't be read.
+	mounts, err := readMountInfo()
+	if err != nil {
+		log.Printf("failed to read the mount table: %v", err)
+	}
+	for _, path := range c.paths {
+		disk, err := diskUsage(path)
+		if err != nil {
+			log.Printf("failed to get statistics for %s: %v", path, err)
+			ch <- prometheus.MustNewConstMetric(errorDesc, prometheus.GaugeValue, 1, path)
+			continue
+		}
+		setMount(disk, mounts)
+		ch <- prometheus.MustNewConstMetric(errorDesc, prometheus.GaugeValue, 0, path)
+
+		var readOnly float64
+		if disk.ReadOnly {
+			readOnly = 1
+		}
+		for _, m := range []struct {
+			desc *prometheus.Desc
+			v    float64
+		}{
+			{sizeDesc, float64(disk.All)},
+			{usedDesc, float64(disk.Used)},
+			{freeDesc, float64(disk.Free)},
+			{availDesc, float64(disk.Avail)},
+			{inodesDesc, float64(disk.Inodes)},
+			{inodesUsedDesc, float64(disk.InodesUsed)},
+			{inodesFreeDesc, float64(disk.InodesFree)},
+			{readOnlyDesc, readOnly},
+		} {
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, m.v, path, disk.FsType)
+		}
+	}
+}
+
+// serveMetrics exposes the statistics of the paths on the /metrics endpoint.
+func serveMetrics(listen string, paths []string) error {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		&diskCollector{paths: paths},
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<html><head><title>diskinfo</title></head><body><a href="/metrics">Metrics</a></body></html>`))
+	})
+
+	log.Println("Listening on", listen)
+	return http.ListenAndServe(listen, mux)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type usageSample struct {
+	Timestamp int64  `json:"t"` // Unix time in milliseconds.
+	Avail     uint64 `json:"avail"`
+}
+
+// forecastState holds the usage samples of the paths between runs.
+type forecastState struct {
+	Samples map[string][]usageSample `json:"samples"`
+}
+
+func loadState(fn string) (*forecastState, error) {
+	st := &forecastState{Samples: make(map[string][]usageSample)}
+	if fn == "" {
+		return st, nil
+	}
+	b, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", fn)
+	}
+	if st.Samples == nil {
+		st.Samples = make(map[string][]usageSample)
+	}
+	return st, nil
+}
+
+// save writes the state atomically.
+func (st *forecastState) save(fn string) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fn), filepath.Base(fn)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fn)
+}
+
+// prune removes the samples older than the retention.
+func (st *forecastState) prune(now time.Time, retention time.Duration) {
+	min := now.Add(-retention).UnixNano() / int64(time.Millisecond)
+	for path, samples := range st.Samples {
+		i := 0
+		for i < len(samples) && samples[i].Timestamp < min {
+			i++
+		}
+		st.Samples[path] = samples[i:]
+	}
+}
+
+type forecast struct {
+	Path    string `json:"path"`
+	Samples int    `json:"samples"`
+	Avail   uint64 `json:"avail"`
+	// Trend of the available bytes per second, negative when the
+	// filesystem fills up.
+	Trend float64 `json:"trend_bytes_per_second"`
+	// Estimated duration until the available bytes reach the threshold, nil
+	// if the available space isn't decreasing.
+	TimeToFull *float64 `json:"time_to_full_seconds,omitempty"`
+	FullAt     string   `json:"full_at,omitempty"`
+}
+
+func (f *forecast) timeToFull() (time.Duration, bool) {
+	if f.TimeToFull == nil {
+		return 0, false
+	}
+	return time.Duration(*f.TimeToFull * float64(time.Second)), true
+}
+
+// linearFit returns the slope of the least squares regression of the
+// available bytes over time, in bytes per second.
+func linearFit(samples []usageSample) float64 {
+	var (
+		n        = float64(len(samples))
+		t0       = samples[0].Timestamp
+		sx, sy   float64
+		sxx, sxy float64
+	)
+	for _, s := range samples {
+		x := float64(s.Timestamp-t0) / 1e3
+		y := float64(s.Avail)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// computeForecast estimates when the available bytes will reach the
+// threshold. At least 2 samples are needed to compute the trend but a path
+// already at or below the threshold is full whatever the trend.
+func computeForecast(path string, samples []usageSample, threshold uint64, now time.Time) *forecast {
+	last := samples[len(samples)-1]
+	f := &forecast{
+		Path:    path,
+		Samples: len(samples),
+		Avail:   last.Avail,
+	}
+	if len(samples) >= 2 {
+		f.Trend = linearFit(samples)
+	}
+	var ttf float64
+	switch {
+	case last.Avail <= threshold:
+	case len(samples) >= 2 && f.Trend < 0:
+		ttf = float64(last.Avail-threshold) / -f.Trend
+	default:
+		return f
+	}
+	f.TimeToFull = &ttf
+	f.FullAt = now.Add(time.Duration(ttf * float64(time.Second))).UTC().Format(time.RFC3339)
+	return f
+}
+
+type forecastOptions struct {
+	interval  time.Duration
+	samples   int
+	stateFile string
+	retention time.Duration
+	threshold uint64
+	horizon   time.Duration
+}
+
+// runForecast samples the available space of the paths and estimates when
+// they will reach the threshold. It returns an error if any path is expected
+// to fill up within the horizon.
+func runForecast(w io.Writer, paths []string, opts forecastOptions, output string, f formatter) error {
+	st, err := loadState(opts.stateFile)
+	if err != nil {
+		return errors.Wrap(err, "loading state")
+	}
+
+	for i := 0; i < opts.samples; i++ {
+		if i > 0 {
+			time.Sleep(opts.interval)
+		}
+		now := time.Now()
+		for _, path := range paths {
+			disk, err := diskUsage(path)
+			if err != nil {
+				return errors.Wrapf(err, "statfs %s", path)
+			}
+			st.Samples[path] = append(st.Samples[path], usageSample{
+				Timestamp: now.UnixNano() / int64(time.Millisecond),
+				Avail:     disk.Avail,
+			})
+		}
+	}
+
+	now := time.Now()
+	if opts.stateFile != "" {
+		st.prune(now, opts.retention)
+		if err := st.save(opts.stateFile); err != nil {
+			return errors.Wrap(err, "saving state")
+		}
+	}
+
+	forecasts := make([]*forecast, 0, len(paths))
+	for _, path := range paths {
+		forecasts = append(forecasts, computeForecast(path, st.Samples[path], opts.threshold, now))
+	}
+
+	if output == "json" {
+		if err := printJSON(w, forecasts); err != nil {
+			return err
+		}
+	} else {
+		for _, fc := range forecasts {
+			fmt.Fprintf(w, "%s: avail %s", fc.Path, f.bytes(fc.Avail))
+			if fc.Samples >= 2 {
+				fmt.Fprintf(w, ", trend %s/h over %d samples", signedBytes(f, fc.Trend*3600), fc.Samples)
+			}
+			ttf, ok := fc.timeToFull()
+			switch {
+			case ok && ttf == 0:
+				fmt.Fprintln(w, ", already full")
+			case ok:
+				fmt.Fprintf(w, ", full in %s (%s)\n", ttf.Round(time.Second), fc.FullAt)
+			case fc.Samples < 2:
+				fmt.Fprintln(w, ", not enough samples to compute a trend")
+			default:
+				fmt.Fprintln(w, ", not filling up")
+			}
+		}
+	}
+
+	if opts.horizon <= 0 {
+		return nil
+	}
+	for _, fc := range forecasts {
+		ttf, ok := fc.timeToFull()
+		if ok && ttf == 0 {
+			return errors.Errorf("%s is already full", fc.Path)
+		}
+		if ok && ttf < opts.horizon {
+			return errors.Errorf("%s is expected to be full in %s (less than %s)", fc.Path, ttf.Round(time.Second), opts.horizon)
+		}
+	}
+	return nil
+}
+
+func signedBytes(f formatter, v float64) string {
+	if v < 0 {
+		return "-" + f.bytes(uint64(-v))
+	}
+	return "+" + f.bytes(uint64(v))
+}
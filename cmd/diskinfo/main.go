@@ -1,22 +1,85 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Mount flags returned by statfs(2).
+const (
+	stRdOnly      = 0x1
+	stNoSuid      = 0x2
+	stNoDev       = 0x4
+	stNoExec      = 0x8
+	stSynchronous = 0x10
+	stMandLock    = 0x40
+	stNoAtime     = 0x400
+	stNoDirAtime  = 0x800
+	stRelAtime    = 0x1000
 )
 
+var mountFlags = []struct {
+	flag int64
+	name string
+}{
+	{stRdOnly, "ro"},
+	{stNoSuid, "nosuid"},
+	{stNoDev, "nodev"},
+	{stNoExec, "noexec"},
+	{stSynchronous, "sync"},
+	{stMandLock, "mand"},
+	{stNoAtime, "noatime"},
+	{stNoDirAtime, "nodiratime"},
+	{stRelAtime, "relatime"},
+}
+
+// Magic numbers of the most common filesystems (see statfs(2)).
+var fsTypes = map[int64]string{
+	0x9123683e: "btrfs",
+	0x27e0eb:   "cgroup",
+	0x63677270: "cgroup2",
+	0x1373:     "devfs",
+	0xef53:     "ext2/ext3/ext4",
+	0x65735546: "fuse",
+	0x6969:     "nfs",
+	0x794c7630: "overlay",
+	0x9fa0:     "proc",
+	0x62656572: "sysfs",
+	0x1021994:  "tmpfs",
+	0x58465342: "xfs",
+	0x2fc12fc1: "zfs",
+}
+
 type diskStatus struct {
-	Bs    uint64 `json:"bs"`
-	All   uint64 `json:"all"`
-	Used  uint64 `json:"used"`
-	Free  uint64 `json:"free"`
-	Avail uint64 `json:"avail"`
+	Path              string   `json:"path"`
+	Device            string   `json:"device,omitempty"`
+	FsType            string   `json:"fstype"`
+	Flags             []string `json:"flags"`
+	ReadOnly          bool     `json:"readonly"`
+	Bs                uint64   `json:"bs"`
+	All               uint64   `json:"all"`
+	Used              uint64   `json:"used"`
+	Free              uint64   `json:"free"`
+	Avail             uint64   `json:"avail"`
+	UsedPercent       float64  `json:"used_percent"`
+	Inodes            uint64   `json:"inodes"`
+	InodesUsed        uint64   `json:"inodes_used"`
+	InodesFree        uint64   `json:"inodes_free"`
+	InodesUsedPercent float64  `json:"inodes_used_percent"`
 }
 
 // disk usage of path/disk
 func diskUsage(path string) (*diskStatus, error) {
-	disk := &diskStatus{}
+	disk := &diskStatus{Path: path}
 	fs := syscall.Statfs_t{}
 	err := syscall.Statfs(path, &fs)
 	if err != nil {
@@ -27,20 +90,275 @@ func diskUsage(path string) (*diskStatus, error) {
 	disk.Free = fs.Bfree * uint64(fs.Bsize)
 	disk.Avail = fs.Bavail * uint64(fs.Bsize)
 	disk.Used = disk.All - disk.Free
+	// Like df, the percentage is computed against the space available to
+	// unprivileged users.
+	disk.UsedPercent = percent(disk.Used, disk.Used+disk.Avail)
+
+	disk.Inodes = fs.Files
+	disk.InodesFree = fs.Ffree
+	disk.InodesUsed = fs.Files - fs.Ffree
+	disk.InodesUsedPercent = percent(disk.InodesUsed, disk.Inodes)
+
+	disk.FsType = fsTypeName(int64(fs.Type))
+	disk.Flags = []string{}
+	for _, f := range mountFlags {
+		if int64(fs.Flags)&f.flag != 0 {
+			disk.Flags = append(disk.Flags, f.name)
+		}
+	}
+	disk.ReadOnly = int64(fs.Flags)&stRdOnly != 0
 	return disk, nil
 }
 
+func fsTypeName(magic int64) string {
+	if name, ok := fsTypes[magic]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", magic)
+}
+
+func percent(v, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(v) / float64(total)
+}
+
+// humanBytes formats a number of bytes with binary prefixes.
+func humanBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// humanCount formats a number with decimal prefixes.
+func humanCount(n uint64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+type formatter struct {
+	human bool
+}
+
+func (f formatter) bytes(b uint64) string {
+	if f.human {
+		return humanBytes(b)
+	}
+	return fmt.Sprintf("%dB", b)
+}
+
+func (f formatter) count(n uint64) string {
+	if f.human {
+		return humanCount(n)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+func printDiskStatus(w io.Writer, disk *diskStatus, f formatter) {
+	fmt.Fprintf(w, "Disk stats from %s\n", disk.Path)
+	fmt.Fprintf(w, "\nFilesystem type: %s\n", disk.FsType)
+	fmt.Fprintf(w, "Mount flags: %s\n", strings.Join(disk.Flags, ","))
+	fmt.Fprintf(w, "\nAll: %s\n", f.bytes(disk.All))
+	fmt.Fprintf(w, "Used: %s (%.1f%%)\n", f.bytes(disk.Used), disk.UsedPercent)
+	fmt.Fprintf(w, "Free: %s\n", f.bytes(disk.Free))
+	fmt.Fprintf(w, "Avail: %s\n", f.bytes(disk.Avail))
+	fmt.Fprintf(w, "(Block size:: %s)\n", f.bytes(disk.Bs))
+	fmt.Fprintf(w, "\nInodes: %s\n", f.count(disk.Inodes))
+	fmt.Fprintf(w, "Inodes used: %s (%.1f%%)\n", f.count(disk.InodesUsed), disk.InodesUsedPercent)
+	fmt.Fprintf(w, "Inodes free: %s\n", f.count(disk.InodesFree))
+}
+
+// printDiskTable prints the filesystems one per line like df.
+func printDiskTable(w io.Writer, disks []*diskStatus, f formatter) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "FILESYSTEM\tTYPE\tSIZE\tUSED\tAVAIL\tUSE%\tINODES\tIUSED\tIFREE\tIUSE%\tMOUNTED ON")
+	for _, d := range disks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.1f%%\t%s\t%s\t%s\t%.1f%%\t%s\n",
+			d.Device, d.FsType, f.bytes(d.All), f.bytes(d.Used), f.bytes(d.Avail), d.UsedPercent,
+			f.count(d.Inodes), f.count(d.InodesUsed), f.count(d.InodesFree), d.InodesUsedPercent, d.Path)
+	}
+}
+
+// stringSlice is a flag that can be repeated.
+type stringSlice []string
+
+func (s *stringSlice) String() string { return strings.Join(*s, ",") }
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
 func main() {
-	var path string
-	flag.StringVar(&path, "path", "/", "Path to inspect")
+	var (
+		paths  stringSlice
+		human  bool
+		output string
+		all    bool
+		listen string
+		du     bool
+
+		forecastMode bool
+		fopts        forecastOptions
+
+		includeTypes, excludeTypes       string
+		includePrefixes, excludePrefixes string
+	)
+	flag.Var(&paths, "path", "Path to inspect, can be repeated (default: /)")
+	flag.BoolVar(&human, "human", false, "Print sizes in human-readable units")
+	flag.StringVar(&output, "output", "text", "Output format (text or json)")
+	flag.BoolVar(&all, "all", false, "Report all the mounted filesystems from /proc/self/mountinfo")
+	flag.StringVar(&includeTypes, "include-type", "", "Comma-separated list of filesystem types to report with --all (default: all except pseudo filesystems)")
+	flag.StringVar(&excludeTypes, "exclude-type", "", "Comma-separated list of filesystem types to skip with --all")
+	flag.StringVar(&includePrefixes, "include-prefix", "", "Comma-separated list of mount point prefixes to report with --all")
+	flag.StringVar(&excludePrefixes, "exclude-prefix", "", "Comma-separated list of mount point prefixes to skip with --all")
+	flag.StringVar(&listen, "listen-address", "", "Expose the statistics of the paths as Prometheus metrics on this address instead of printing them")
+	flag.BoolVar(&du, "du", false, "Report the usage of each top-level entry of the paths, identifying the Prometheus data directory layout")
+	flag.BoolVar(&forecastMode, "forecast", false, "Sample the available space and estimate when the paths will be full")
+	flag.DurationVar(&fopts.interval, "forecast.interval", 10*time.Second, "Interval between samples in forecast mode")
+	flag.IntVar(&fopts.samples, "forecast.samples", 2, "Number of samples to take in forecast mode")
+	flag.StringVar(&fopts.stateFile, "forecast.state-file", "", "File persisting the samples between runs in forecast mode")
+	flag.DurationVar(&fopts.retention, "forecast.retention", 7*24*time.Hour, "How long samples are kept in the state file")
+	flag.Uint64Var(&fopts.threshold, "forecast.threshold", 0, "Available bytes considered as full")
+	flag.DurationVar(&fopts.horizon, "forecast.horizon", 0, "Exit with an error if a path is expected to be full within this duration")
 	flag.Parse()
-	fmt.Printf("Disk stats from %s\n", path)
-	disk, err := diskUsage(path)
-	if err != nil {
-		panic(err)
+
+	if len(paths) == 0 {
+		paths = stringSlice{"/"}
+	}
+
+	if output != "text" && output != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid --output parameter %q.\n", output)
+		os.Exit(1)
+	}
+	f := formatter{human: human}
+
+	var modes int
+	for _, m := range []bool{listen != "", du, forecastMode, all} {
+		if m {
+			modes++
+		}
+	}
+	if modes > 1 {
+		fmt.Fprintln(os.Stderr, "Only one of --listen-address, --du, --forecast and --all can be given.")
+		os.Exit(1)
+	}
+
+	if listen != "" {
+		if err := serveMetrics(listen, paths); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if du {
+		for i, path := range paths {
+			usages, err := dirUsages(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if output == "json" {
+				if err := printJSON(os.Stdout, usages); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				continue
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("Usage of %s\n\n", path)
+			printDirUsages(os.Stdout, usages, f)
+		}
+		return
+	}
+
+	if forecastMode {
+		if fopts.samples < 1 {
+			fmt.Fprintln(os.Stderr, "Invalid --forecast.samples parameter.")
+			os.Exit(1)
+		}
+		if err := runForecast(os.Stdout, paths, fopts, output, f); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if all {
+		filter := mountFilter{
+			includeTypes:    splitList(includeTypes),
+			excludeTypes:    splitList(excludeTypes),
+			includePrefixes: splitList(includePrefixes),
+			excludePrefixes: splitList(excludePrefixes),
+		}
+		disks, err := allDiskUsage(filter)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if output == "json" {
+			if err := printJSON(os.Stdout, disks); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+		printDiskTable(os.Stdout, disks, f)
+		return
+	}
+
+	// Like in the exporter, the statfs(2) type is kept if the mount table
+	// can't be read.
+	mounts, _ := readMountInfo()
+	disks := make([]*diskStatus, 0, len(paths))
+	for _, path := range paths {
+		disk, err := diskUsage(path)
+		if err != nil {
+			err = errors.Wrapf(err, "statfs %s", path)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		setMount(disk, mounts)
+		disks = append(disks, disk)
+	}
+
+	if output == "json" {
+		if err := printJSON(os.Stdout, disks); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	for i, disk := range disks {
+		if i > 0 {
+			fmt.Println()
+		}
+		printDiskStatus(os.Stdout, disk, f)
 	}
-	fmt.Printf("\nAll: %dB\n", disk.All)
-	fmt.Printf("Used: %dB\n", disk.Used)
-	fmt.Printf("Avail: %dB\n", disk.Avail)
-	fmt.Printf("(Block size:: %dB)\n", disk.Bs)
 }
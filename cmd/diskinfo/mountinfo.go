@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pseudoFsTypes are the filesystems which don't store any data.
+var pseudoFsTypes = map[string]struct{}{
+	"autofs":      {},
+	"binfmt_misc": {},
+	"bpf":         {},
+	"cgroup":      {},
+	"cgroup2":     {},
+	"configfs":    {},
+	"debugfs":     {},
+	"devpts":      {},
+	"efivarfs":    {},
+	"fusectl":     {},
+	"hugetlbfs":   {},
+	"mqueue":      {},
+	"nsfs":        {},
+	"proc":        {},
+	"pstore":      {},
+	"rpc_pipefs":  {},
+	"securityfs":  {},
+	"selinuxfs":   {},
+	"sysfs":       {},
+	"tracefs":     {},
+}
+
+type mount struct {
+	mountPoint string
+	fsType     string
+	source     string
+}
+
+// parseMountInfo parses the content of /proc/<pid>/mountinfo. The format is
+// described in proc(5):
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfo(r io.Reader) ([]mount, error) {
+	var (
+		mounts  []mount
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		// The optional fields are terminated by a single hyphen.
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 7 || sep < 0 || len(fields) < sep+3 {
+			return nil, errors.Errorf("invalid mountinfo line %q", scanner.Text())
+		}
+		mounts = append(mounts, mount{
+			mountPoint: unescapeOctal(fields[4]),
+			fsType:     fields[sep+1],
+			source:     unescapeOctal(fields[sep+2]),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+func readMountInfo() ([]mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountInfo(f)
+}
+
+// mountOf returns the mount containing the path, that is the visible mount
+// with the longest mount point prefixing the path.
+func mountOf(mounts []mount, path string) (mount, bool) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return mount{}, false
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	var (
+		found mount
+		ok    bool
+	)
+	for _, m := range mounts {
+		if m.mountPoint != "/" && path != m.mountPoint && !strings.HasPrefix(path, m.mountPoint+"/") {
+			continue
+		}
+		// Later mounts hide the earlier ones on the same mount point.
+		if !ok || len(m.mountPoint) >= len(found.mountPoint) {
+			found, ok = m, true
+		}
+	}
+	return found, ok
+}
+
+// setMount sets the filesystem type and device of the disk from the mount
+// table which gives the real type names (e.g. ext4) unlike statfs(2).
+func setMount(disk *diskStatus, mounts []mount) {
+	if m, ok := mountOf(mounts, disk.Path); ok {
+		disk.FsType = m.fsType
+		disk.Device = m.source
+	}
+}
+
+// unescapeOctal replaces the octal escape sequences (e.g. '\040' for space)
+// used by the kernel for special characters.
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+type mountFilter struct {
+	includeTypes, excludeTypes       []string
+	includePrefixes, excludePrefixes []string
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns whether the mount should be reported. Pseudo filesystems are
+// skipped unless their type is explicitly included.
+func (f mountFilter) match(m mount) bool {
+	if len(f.includeTypes) > 0 {
+		if !contains(f.includeTypes, m.fsType) {
+			return false
+		}
+	} else if _, ok := pseudoFsTypes[m.fsType]; ok {
+		return false
+	}
+	if contains(f.excludeTypes, m.fsType) {
+		return false
+	}
+	if len(f.includePrefixes) > 0 && !hasAnyPrefix(m.mountPoint, f.includePrefixes) {
+		return false
+	}
+	return !hasAnyPrefix(m.mountPoint, f.excludePrefixes)
+}
+
+// allDiskUsage returns the usage of all the mounted filesystems matching the
+// filter, sorted by mount point.
+func allDiskUsage(f mountFilter) ([]*diskStatus, error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// A mount point can be mounted over several times, only the last one is
+	// visible.
+	visible := make(map[string]mount)
+	for _, m := range mounts {
+		visible[m.mountPoint] = m
+	}
+
+	var disks []*diskStatus
+	for _, m := range visible {
+		if !f.match(m) {
+			continue
+		}
+		disk, err := diskUsage(m.mountPoint)
+		if err != nil {
+			// Some mount points can't be inspected by unprivileged users.
+			if os.IsPermission(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "statfs %s", m.mountPoint)
+		}
+		disk.FsType = m.fsType
+		disk.Device = m.source
+		disks = append(disks, disk)
+	}
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Path < disks[j].Path })
+	return disks, nil
+}
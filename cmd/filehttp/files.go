@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// etag derives a validator from the size and modification time of the file.
+func etag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+// serveFile streams the file from disk. http.ServeContent takes care of the
+// content type, Range, If-Modified-Since and If-None-Match headers.
+func serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", etag(fi))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case os.IsPermission(err):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+	default:
+		log.Println(err)
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// dirHandler serves the files under root.
+type dirHandler struct {
+	root    string
+	listing bool
+}
+
+func newDirHandler(dir string, listing bool) (*dirHandler, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s isn't a directory", dir)
+	}
+	return &dirHandler{root: root, listing: listing}, nil
+}
+
+// resolve maps the URL path to a file under the root directory. It returns an
+// error if the path or one of its symlinks points outside of the root.
+func (h *dirHandler) resolve(urlPath string) (string, error) {
+	// Cleaning a rooted path removes all the ".." elements.
+	name := filepath.Join(h.root, filepath.FromSlash(path.Clean("/"+urlPath)))
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	if resolved != h.root && !strings.HasPrefix(resolved, h.root+string(filepath.Separator)) {
+		return "", os.ErrPermission
+	}
+	return resolved, nil
+}
+
+func (h *dirHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, err := h.resolve(r.URL.Path)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if !fi.IsDir() {
+		serveFile(w, r, name)
+		return
+	}
+
+	// Relative links in listings only work with a trailing slash.
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return
+	}
+	index := filepath.Join(name, "index.html")
+	if _, err := os.Stat(index); err == nil {
+		serveFile(w, r, index)
+		return
+	}
+	if !h.listing {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+	h.serveListing(w, r, name)
+}
+
+func (h *dirHandler) serveListing(w http.ResponseWriter, r *http.Request, dir string) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head><title>Index of %[1]s</title></head>\n<body>\n<h1>Index of %[1]s</h1>\n<pre>\n", html.EscapeString(r.URL.Path))
+	if r.URL.Path != "/" {
+		fmt.Fprintln(w, `<a href="../">../</a>`)
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\t%s\t%d\n", link.String(), html.EscapeString(name), fi.ModTime().UTC().Format("2006-01-02 15:04:05"), fi.Size())
+	}
+	fmt.Fprintln(w, "</pre>\n</body>\n</html>")
+}
@@ -1,52 +1,178 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/simonpasquier/sandbox/pkg/tracing"
 )
 
 var (
-	help         bool
-	listen, file string
+	help                      bool
+	listen, file, dir, config string
+	listing                   bool
+	reloadInterval            time.Duration
+	tmpl                      bool
+
+	tlsCert, tlsKey, tlsClientCA string
+	htpasswd                     string
+	accessLogs                   bool
+	shutdownTimeout              time.Duration
+
+	healthyPath, readyPath, metricsPath, tracePath string
+
+	traceDir      string
+	traceDuration time.Duration
 )
 
 func init() {
 	flag.BoolVar(&help, "help", false, "Help message")
 	flag.StringVar(&listen, "listen-address", ":8080", "Listen address")
-	flag.StringVar(&file, "file", "", "File to serve")
+	flag.StringVar(&file, "file", "", "File to serve for every path")
+	flag.StringVar(&dir, "dir", "", "Directory tree to serve")
+	flag.DurationVar(&reloadInterval, "reload-interval", 0, "Keep the file in memory and check for changes at this interval, the last good version is served if the file can't be read (with --file, by default the file is streamed from disk for every request)")
+	flag.BoolVar(&tmpl, "template", false, "Render the file as a Go text/template for every request (with --file)")
+	flag.StringVar(&config, "config", "", "Configuration file of the mock endpoints")
+	flag.BoolVar(&listing, "listing", false, "Render the listing of directories without index.html (with --dir)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "Certificate file to serve HTTPS")
+	flag.StringVar(&tlsKey, "tls-key", "", "Private key file to serve HTTPS")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "CA file verifying the client certificates (mutual TLS)")
+	flag.StringVar(&htpasswd, "htpasswd", "", "File with the users allowed to connect with basic auth (bcrypt hashes only)")
+	flag.BoolVar(&accessLogs, "access-log", true, "Log every request")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "Time given to the in-flight requests to complete on SIGTERM")
+	flag.StringVar(&healthyPath, "web.healthy-path", "/-/healthy", "Path of the health endpoint (empty to disable)")
+	flag.StringVar(&readyPath, "web.ready-path", "/-/ready", "Path of the readiness endpoint (empty to disable)")
+	flag.StringVar(&tracePath, "web.trace-path", "", "Path of the endpoint capturing Go execution traces, e.g. /-/trace to capture with /-/trace?duration=5s (default: disabled)")
+	flag.StringVar(&traceDir, "trace.dir", os.TempDir(), "Directory of the Go execution traces captured on SIGUSR1")
+	flag.DurationVar(&traceDuration, "trace.duration", tracing.DefaultDuration, "Duration of the Go execution traces captured on SIGUSR1")
+	flag.StringVar(&metricsPath, "web.metrics-path", "/-/metrics", "Path of the metrics endpoint (empty to disable), set it to /metrics if the served content doesn't use that path")
+}
+
+// newHandler returns the handler of the content. The stop channel is closed
+// on shutdown.
+func newHandler(stop <-chan struct{}) (http.Handler, error) {
+	switch {
+	case config != "":
+		cfg, err := loadMockConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return &mockHandler{routes: cfg.Routes}, nil
+	case dir != "":
+		return newDirHandler(dir, listing)
+	// By default the file is streamed from disk for every request: changes
+	// are picked up immediately and large files don't need to fit in memory
+	// but a request may see a partially written file and fails if the file
+	// is missing. With --reload-interval, the file is kept in memory and
+	// swapped atomically which suits small payloads rewritten in place
+	// (e.g. fake /metrics) at the cost of holding the whole file in RAM.
+	case reloadInterval > 0 || tmpl:
+		r, err := newReloader(file)
+		if err != nil {
+			return nil, err
+		}
+		if reloadInterval > 0 {
+			go r.run(reloadInterval, stop)
+		}
+		if tmpl {
+			return newTemplateHandler(r, reloadInterval == 0)
+		}
+		return r, nil
+	}
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveFile(w, r, file)
+	}), nil
 }
 
 func main() {
 	flag.Parse()
 	if help {
-		fmt.Fprintln(os.Stderr, "Simple HTTP server rendering a static file")
+		fmt.Fprintln(os.Stderr, "Simple HTTP server rendering a static file, a directory tree or mock endpoints")
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
 
-	if file == "" {
-		fmt.Fprintln(os.Stderr, "Missing --file parameter.")
+	var modes int
+	for _, s := range []string{file, dir, config} {
+		if s != "" {
+			modes++
+		}
+	}
+	if modes != 1 {
+		fmt.Fprintln(os.Stderr, "Expecting one of --file, --dir or --config parameters.")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if (tlsCert == "") != (tlsKey == "") || (tlsClientCA != "" && tlsCert == "") {
+		fmt.Fprintln(os.Stderr, "--tls-cert and --tls-key are both required to serve HTTPS.")
+		os.Exit(1)
+	}
 
-	b, err := ioutil.ReadFile(file)
+	stop := make(chan struct{})
+	content, err := newHandler(stop)
 	if err != nil {
 		log.Fatal(err)
 	}
+	e := newEndpoints(healthyPath, readyPath, metricsPath, tracePath, content)
+	var h http.Handler = e
+	if htpasswd != "" {
+		users, err := loadHtpasswd(htpasswd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h = basicAuth(users, h)
+	}
+	if accessLogs {
+		h = accessLog(h)
+	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		_, err := w.Write(b)
-
+	srv := &http.Server{Addr: listen, Handler: h}
+	if tlsCert != "" {
+		srv.TLSConfig, err = tlsConfig(tlsCert, tlsKey, tlsClientCA)
 		if err != nil {
-			log.Println(err)
+			log.Fatal(err)
 		}
-	})
+	}
+
+	stopTracing := tracing.NotifySignal(traceDir, traceDuration, nil, syscall.SIGUSR1)
+	defer stopTracing()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		term := make(chan os.Signal, 1)
+		signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+		sig := <-term
+		log.Printf("Received %s, shutting down", sig)
+		e.setReady(false)
+		close(stop)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("Error during shutdown:", err)
+		}
+	}()
 
 	log.Println("Listening on", listen)
-	log.Fatal(http.ListenAndServe(listen, nil))
+	e.setReady(true)
+	if srv.TLSConfig != nil {
+		// The certificates are already loaded in the TLS configuration.
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
 }
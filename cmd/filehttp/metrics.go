@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/simonpasquier/sandbox/pkg/tracing"
+)
+
+// instrument returns the content handler instrumented with metrics
+// registered in reg.
+func instrument(reg prometheus.Registerer, next http.Handler) http.Handler {
+	var (
+		requests = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "filehttp",
+				Name:      "requests_total",
+				Help:      "Total number of HTTP requests by status code and method.",
+			},
+			[]string{"code", "method"},
+		)
+		duration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "filehttp",
+				Name:      "request_duration_seconds",
+				Help:      "Latency of the HTTP requests.",
+				Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+			},
+			[]string{"code", "method"},
+		)
+		size = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "filehttp",
+				Name:      "response_size_bytes",
+				Help:      "Size of the HTTP responses.",
+				Buckets:   prometheus.ExponentialBuckets(100, 10, 7),
+			},
+			[]string{"code", "method"},
+		)
+		inFlight = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "filehttp",
+				Name:      "requests_in_flight",
+				Help:      "Number of HTTP requests being served.",
+			},
+		)
+	)
+	reg.MustRegister(requests, duration, size, inFlight)
+
+	return promhttp.InstrumentHandlerInFlight(inFlight,
+		promhttp.InstrumentHandlerCounter(requests,
+			promhttp.InstrumentHandlerDuration(duration,
+				promhttp.InstrumentHandlerResponseSize(size, next),
+			),
+		),
+	)
+}
+
+// endpoints serves the health, readiness, metrics and tracing endpoints next
+// to the content. An empty path disables the endpoint.
+type endpoints struct {
+	healthyPath, readyPath, metricsPath, tracePath string
+
+	ready   int32
+	metrics http.Handler
+	content http.Handler
+}
+
+func newEndpoints(healthyPath, readyPath, metricsPath, tracePath string, content http.Handler) *endpoints {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return &endpoints{
+		healthyPath: healthyPath,
+		readyPath:   readyPath,
+		metricsPath: metricsPath,
+		tracePath:   tracePath,
+		metrics:     promhttp.HandlerFor(reg, promhttp.HandlerOpts{}),
+		content:     instrument(reg, content),
+	}
+}
+
+// setReady changes the status reported by the readiness endpoint.
+func (e *endpoints) setReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&e.ready, v)
+}
+
+func (e *endpoints) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch p := r.URL.Path; {
+	case p == "":
+		// Never match the disabled endpoints.
+	case p == e.healthyPath:
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Healthy.\n"))
+		return
+	case p == e.readyPath:
+		if atomic.LoadInt32(&e.ready) == 0 {
+			http.Error(w, "Not ready.", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Ready.\n"))
+		return
+	case p == e.metricsPath:
+		e.metrics.ServeHTTP(w, r)
+		return
+	case p == e.tracePath:
+		tracing.Handler().ServeHTTP(w, r)
+		return
+	}
+	ctx, end := tracing.StartTask(r.Context(), "request")
+	defer end()
+	e.content.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/simonpasquier/sandbox/pkg/tracing"
+	"gopkg.in/yaml.v2"
+)
+
+// mockConfig is the configuration of the mock-endpoint mode, for instance:
+//
+//	routes:
+//	  - path: /repos/*/*/releases
+//	    method: GET
+//	    file: releases.json
+//	    headers:
+//	      Content-Type: application/json
+//	  - path: /metrics
+//	    file: metrics.txt
+//	    latency: 2s
+//	    jitter: 1s
+//	    error_rate: 0.2
+//	  - path: /api/v1/alerts
+//	    method: POST
+//	    status: 202
+//	  - path: /api/v1/query
+//	    template: true
+//	    body: '{"status":"success","data":{"value":[{{ now.Unix }},"{{ counter "q" }}"]}}'
+//
+// Paths are matched in order with path.Match and files are relative to the
+// directory of the configuration file. Template bodies and files are rendered
+// for every request.
+type mockConfig struct {
+	Routes []*mockRoute `yaml:"routes"`
+}
+
+type mockRoute struct {
+	Path    string            `yaml:"path"`
+	Method  string            `yaml:"method,omitempty"`
+	File    string            `yaml:"file,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+	Status  int               `yaml:"status,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Latency added before responding, plus a random duration up to Jitter.
+	Latency model.Duration `yaml:"latency,omitempty"`
+	Jitter  model.Duration `yaml:"jitter,omitempty"`
+	// Share of the requests (between 0 and 1) failing with ErrorStatus.
+	ErrorRate   float64 `yaml:"error_rate,omitempty"`
+	ErrorStatus int     `yaml:"error_status,omitempty"`
+	Template    bool    `yaml:"template,omitempty"`
+
+	tmpl *template.Template
+}
+
+func loadMockConfig(name string) (*mockConfig, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &mockConfig{}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", name)
+	}
+
+	dir := filepath.Dir(name)
+	for i, r := range cfg.Routes {
+		if _, err := path.Match(r.Path, "/"); err != nil || !strings.HasPrefix(r.Path, "/") {
+			return nil, errors.Errorf("route %d: invalid path %q", i, r.Path)
+		}
+		if r.File != "" && r.Body != "" {
+			return nil, errors.Errorf("route %d: file and body are mutually exclusive", i)
+		}
+		if r.File != "" && !filepath.IsAbs(r.File) {
+			r.File = filepath.Join(dir, r.File)
+		}
+		r.Method = strings.ToUpper(r.Method)
+		if r.Status == 0 {
+			r.Status = http.StatusOK
+		}
+		if r.ErrorRate < 0 || r.ErrorRate > 1 {
+			return nil, errors.Errorf("route %d: error_rate must be between 0 and 1", i)
+		}
+		if r.Template && r.File == "" {
+			if r.tmpl, err = parseTemplate(r.Path, []byte(r.Body)); err != nil {
+				return nil, errors.Wrapf(err, "route %d", i)
+			}
+		}
+		if r.ErrorStatus == 0 {
+			r.ErrorStatus = http.StatusServiceUnavailable
+		}
+	}
+	return cfg, nil
+}
+
+func (r *mockRoute) delay() time.Duration {
+	d := time.Duration(r.Latency)
+	if r.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(r.Jitter)))
+	}
+	return d
+}
+
+// mockHandler responds with the first route matching the request.
+type mockHandler struct {
+	routes []*mockRoute
+}
+
+func (h *mockHandler) match(req *http.Request) (*mockRoute, int) {
+	status := http.StatusNotFound
+	for _, r := range h.routes {
+		if ok, _ := path.Match(r.Path, req.URL.Path); !ok {
+			continue
+		}
+		if r.Method != "" && r.Method != req.Method {
+			status = http.StatusMethodNotAllowed
+			continue
+		}
+		return r, 0
+	}
+	return nil, status
+}
+
+func (h *mockHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r, status := h.match(req)
+	if r == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if d := r.delay(); d > 0 {
+		region := tracing.StartRegion(req.Context(), "latency")
+		select {
+		case <-time.After(d):
+		case <-req.Context().Done():
+		}
+		region.End()
+		if req.Context().Err() != nil {
+			return
+		}
+	}
+	if r.ErrorRate > 0 && rand.Float64() < r.ErrorRate {
+		http.Error(w, http.StatusText(r.ErrorStatus), r.ErrorStatus)
+		return
+	}
+
+	if r.Template {
+		tmpl := r.tmpl
+		if tmpl == nil {
+			b, err := ioutil.ReadFile(r.File)
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+			if tmpl, err = parseTemplate(r.File, b); err != nil {
+				log.Println(err)
+				http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		for k, v := range r.Headers {
+			w.Header().Set(k, v)
+		}
+		renderTemplate(w, req, tmpl, r.Status)
+		return
+	}
+
+	var body io.Reader = strings.NewReader(r.Body)
+	if r.File != "" {
+		f, err := os.Open(r.File)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		defer f.Close()
+		body = f
+		if ct := mime.TypeByExtension(filepath.Ext(r.File)); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+	}
+	for k, v := range r.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(r.Status)
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		log.Println(err)
+	}
+}
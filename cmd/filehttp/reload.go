@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// snapshot is an immutable version of the served file.
+type snapshot struct {
+	data    []byte
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// reloader keeps the file in memory and reloads it when its modification
+// time or size changes. Requests always see a complete version of the file:
+// if the file can't be read, the last good version is served.
+type reloader struct {
+	name    string
+	current atomic.Value // *snapshot
+	// Whether the last reload failed, to log the failures only once until
+	// the file is readable again.
+	failing int32
+}
+
+func newReloader(name string) (*reloader, error) {
+	r := &reloader{name: name}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(fi); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *reloader) load(fi os.FileInfo) error {
+	b, err := ioutil.ReadFile(r.name)
+	if err != nil {
+		return err
+	}
+	r.current.Store(&snapshot{
+		data:    b,
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+		etag:    fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), len(b)),
+	})
+	return nil
+}
+
+// reloadIfChanged loads the file if it has changed since the last load.
+func (r *reloader) reloadIfChanged() error {
+	fi, err := os.Stat(r.name)
+	if err != nil {
+		return err
+	}
+	cur := r.current.Load().(*snapshot)
+	if fi.ModTime().Equal(cur.modTime) && fi.Size() == cur.size {
+		return nil
+	}
+	if err := r.load(fi); err != nil {
+		return err
+	}
+	log.Println("Reloaded", r.name)
+	return nil
+}
+
+// run polls the file until the stop channel is closed.
+func (r *reloader) run(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		r.reload()
+	}
+}
+
+// reload reloads the file if it has changed and logs the first failure.
+func (r *reloader) reload() {
+	err := r.reloadIfChanged()
+	if err == nil {
+		atomic.StoreInt32(&r.failing, 0)
+		return
+	}
+	if atomic.SwapInt32(&r.failing, 1) == 0 {
+		log.Printf("Failed to reload %s, serving the last good version: %v", r.name, err)
+	}
+}
+
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s := r.current.Load().(*snapshot)
+	w.Header().Set("ETag", s.etag)
+	http.ServeContent(w, req, filepath.Base(r.name), s.modTime, bytes.NewReader(s.data))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// statusRecorder records the status code and the number of bytes of the
+// response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// accessLog logs every request in logfmt.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		user, _, _ := r.BasicAuth()
+		log.Printf("remote=%s user=%q method=%s path=%q status=%d bytes=%d duration=%s",
+			r.RemoteAddr, user, r.Method, r.URL.RequestURI(), rec.code(), rec.bytes, time.Since(start))
+	})
+}
+
+// loadHtpasswd reads the users and their bcrypt hashes from a file in the
+// htpasswd format (as generated by "htpasswd -B").
+func loadHtpasswd(name string) (map[string][]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, errors.Errorf("%s:%d: expecting <user>:<hash>", name, n)
+		}
+		hash := []byte(line[i+1:])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, errors.Wrapf(err, "%s:%d: only bcrypt hashes are supported", name, n)
+		}
+		users[line[:i]] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// basicAuth rejects the requests without valid credentials.
+func basicAuth(users map[string][]byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if ok {
+			if hash, found := users[user]; found && bcrypt.CompareHashAndPassword(hash, []byte(pass)) == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="filehttp"`)
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// tlsConfig returns the server's TLS configuration. Client certificates are
+// required and verified when a CA file is given.
+func tlsConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading certificate")
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		b, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client CA")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificate found in %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/simonpasquier/sandbox/pkg/tracing"
+)
+
+var counters = struct {
+	sync.Mutex
+	m map[string]int64
+}{m: make(map[string]int64)}
+
+// templateFuncs are the helpers available to the templates.
+var templateFuncs = template.FuncMap{
+	"now": time.Now,
+	// counter increments the named counter and returns its new value.
+	"counter": func(name string) int64 {
+		counters.Lock()
+		defer counters.Unlock()
+		counters.m[name]++
+		return counters.m[name]
+	},
+	"random": rand.Float64,
+	"randomInt": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + rand.Intn(max-min)
+	},
+}
+
+// templateData is the request data available to the templates.
+type templateData struct {
+	Method     string
+	Path       string
+	Query      url.Values
+	Header     http.Header
+	Host       string
+	RemoteAddr string
+}
+
+func parseTemplate(name string, text []byte) (*template.Template, error) {
+	return template.New(filepath.Base(name)).Funcs(templateFuncs).Parse(string(text))
+}
+
+// renderTemplate executes the template for the request and writes the
+// result. The content type is detected from the name of the template.
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl *template.Template, status int) {
+	region := tracing.StartRegion(r.Context(), "template")
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, &templateData{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Header:     r.Header,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+	})
+	region.End()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		ct := mime.TypeByExtension(filepath.Ext(tmpl.Name()))
+		if ct == "" {
+			ct = http.DetectContentType(buf.Bytes())
+		}
+		w.Header().Set("Content-Type", ct)
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(buf.Bytes())
+		}
+		return
+	}
+	// The content changes for every request so there is no modification
+	// time nor ETag.
+	http.ServeContent(w, r, tmpl.Name(), time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// templateHandler renders the file as a template. The template is parsed
+// again only when the file's content changes.
+type templateHandler struct {
+	r *reloader
+	// Whether the file should be checked for changes on every request
+	// because it isn't polled.
+	check bool
+
+	mu   sync.Mutex
+	src  *snapshot
+	tmpl *template.Template
+}
+
+func newTemplateHandler(r *reloader, check bool) (*templateHandler, error) {
+	h := &templateHandler{r: r, check: check}
+	if _, err := h.template(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *templateHandler) template() (*template.Template, error) {
+	s := h.r.current.Load().(*snapshot)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s == h.src {
+		return h.tmpl, nil
+	}
+	tmpl, err := parseTemplate(h.r.name, s.data)
+	if err != nil {
+		if h.tmpl == nil {
+			return nil, errors.Wrapf(err, "parsing template %s", h.r.name)
+		}
+		// Keep the last good template.
+		log.Printf("Failed to parse template %s, using the last good version: %v", h.r.name, err)
+		tmpl = h.tmpl
+	}
+	h.src, h.tmpl = s, tmpl
+	return tmpl, nil
+}
+
+func (h *templateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.check {
+		h.r.reload()
+	}
+	tmpl, err := h.template()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	renderTemplate(w, r, tmpl, http.StatusOK)
+}
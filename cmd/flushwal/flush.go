@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// rangeHead restricts a head to the chunks overlapping the closed interval
+// [mint, maxt].
+type rangeHead struct {
+	head       *tsdb.Head
+	mint, maxt int64
+}
+
+func (h *rangeHead) Index() (tsdb.IndexReader, error) {
+	ir, err := h.head.Index()
+	if err != nil {
+		return nil, err
+	}
+	return &rangeIndexReader{IndexReader: ir, mint: h.mint, maxt: h.maxt}, nil
+}
+
+func (h *rangeHead) Chunks() (tsdb.ChunkReader, error) {
+	return h.head.Chunks()
+}
+
+func (h *rangeHead) Tombstones() (tombstones.Reader, error) {
+	return h.head.Tombstones()
+}
+
+func (h *rangeHead) Meta() tsdb.BlockMeta {
+	meta := h.head.Meta()
+	meta.MinTime, meta.MaxTime = h.mint, h.maxt
+	return meta
+}
+
+// rangeIndexReader only returns the chunks overlapping [mint, maxt].
+type rangeIndexReader struct {
+	tsdb.IndexReader
+	mint, maxt int64
+}
+
+func (r *rangeIndexReader) Series(ref uint64, lset *labels.Labels, chks *[]chunks.Meta) error {
+	if err := r.IndexReader.Series(ref, lset, chks); err != nil {
+		return err
+	}
+	filtered := (*chks)[:0]
+	for _, c := range *chks {
+		if c.OverlapsClosedInterval(r.mint, r.maxt) {
+			filtered = append(filtered, c)
+		}
+	}
+	*chks = filtered
+	return nil
+}
+
+// flushAligned writes the content of the WAL to dir as blocks aligned on
+// multiples of the given duration. Like DBReadOnly.FlushWAL(), samples that
+// are older than maxBlockTime (the end of the most recent persisted block) are
+// skipped.
+func flushAligned(logger log.Logger, dataDir, dir string, d time.Duration, maxBlockTime int64) error {
+	// wal.Open returns a read-only WAL which doesn't hold any file: the head
+	// opens the segments while replaying them in Init() and closes them
+	// before returning. The WAL and the head must not be closed, WAL.Close()
+	// expects a segment opened for writing and panics otherwise. This is the
+	// same as DBReadOnly.FlushWAL().
+	w, err := wal.Open(logger, nil, filepath.Join(dataDir, "wal"))
+	if err != nil {
+		return err
+	}
+	// The chunk range is set to the block duration so that the head cuts
+	// chunks on the block boundaries and no chunk spans over 2 blocks.
+	width := int64(d / time.Millisecond)
+	head, err := tsdb.NewHead(nil, logger, w, width, tsdb.DefaultStripeSize)
+	if err != nil {
+		return err
+	}
+	if err := head.Init(maxBlockTime); err != nil {
+		return errors.Wrap(err, "read WAL")
+	}
+
+	compactor, err := tsdb.NewLeveledCompactor(context.Background(), nil, logger, tsdb.DefaultOptions.BlockRanges, chunkenc.NewPool())
+	if err != nil {
+		return errors.Wrap(err, "create leveled compactor")
+	}
+
+	if head.MinTime() > head.MaxTime() {
+		// Empty head.
+		return nil
+	}
+	for mint := (head.MinTime() / width) * width; mint <= head.MaxTime(); mint += width {
+		// Block intervals are half-open: [mint, maxt) while chunk intervals are
+		// closed.
+		maxt := mint + width
+		bmint := mint
+		if bmint < maxBlockTime {
+			// Don't overlap with the last persisted block.
+			bmint = maxBlockTime
+		}
+		rh := &rangeHead{head: head, mint: bmint, maxt: maxt - 1}
+		if _, err := compactor.Write(dir, rh, bmint, maxt, nil); err != nil {
+			return errors.Wrapf(err, "writing block [%d, %d)", bmint, maxt)
+		}
+	}
+	return nil
+}
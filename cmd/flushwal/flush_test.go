@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// openFiles returns the files under dir opened by the process.
+func openFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("can't list the open files:", err)
+	}
+	var files []string
+	for _, fd := range fds {
+		name, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(name, dir+string(filepath.Separator)) {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+func TestFlushAlignedReleasesWAL(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "flushwal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(tmp, "data")
+	writeWAL(t, dataDir)
+	out := filepath.Join(tmp, "out")
+	if err := os.Mkdir(out, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flushAligned(log.NewNopLogger(), dataDir, out, time.Hour, math.MinInt64); err != nil {
+		t.Fatal(err)
+	}
+	// The WAL is truncated or moved to the backup dir right after the flush.
+	if files := openFiles(t, filepath.Join(dataDir, "wal")); len(files) > 0 {
+		t.Fatalf("WAL files still open after the flush: %v", files)
+	}
+}
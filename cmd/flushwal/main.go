@@ -1,34 +1,104 @@
+// Utility program to flush the WAL of a stopped Prometheus server into
+// persistent blocks.
 package main
 
 import (
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
 	"github.com/prometheus/prometheus/tsdb/wal"
 )
 
+var (
+	help          bool
+	dryRun        bool
+	transactional bool
+	keepBackup    bool
+	backupDir     string
+	blockDuration time.Duration
+	verify        bool
+)
+
+func init() {
+	flag.BoolVar(&help, "help", false, "Show help")
+	flag.BoolVar(&dryRun, "dry-run", false, "Flush the WAL into a temporary directory and report the blocks without modifying the data directory")
+	flag.BoolVar(&transactional, "transactional", false, "Move the WAL aside before installing the blocks and restore it if any step fails")
+	flag.StringVar(&backupDir, "backup-dir", "", "Directory where the WAL is moved in transactional mode, it must be on the same filesystem as the data directory (default: <data dir>/flushwal-backup-<timestamp>)")
+	flag.DurationVar(&blockDuration, "block-duration", 0, "Split the flushed data into blocks aligned on this duration, e.g. 2h (default: a single block)")
+	flag.BoolVar(&verify, "verify", true, "Compare the flushed blocks with the WAL before modifying the data directory")
+	flag.BoolVar(&keepBackup, "keep-backup", false, "Keep the backup of the WAL after a successful transactional flush")
+}
+
 func main() {
+	flag.Parse()
+	if help {
+		fmt.Fprintln(os.Stderr, "Usage: flushwal [flags] <data dir>")
+		fmt.Fprintln(os.Stderr, "Flushes the WAL of a stopped Prometheus server into blocks.")
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	if blockDuration < 0 || blockDuration%time.Millisecond != 0 {
+		fmt.Fprintln(os.Stderr, "Invalid --block-duration parameter.")
+		os.Exit(1)
+	}
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Expecting the data directory as the only argument.")
+		os.Exit(1)
+	}
+
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	logger = level.NewFilter(logger, level.AllowAll())
-	if err := run(logger); err != nil {
+	if err := run(logger, flag.Arg(0)); err != nil {
 		level.Error(logger).Log("err", err)
 		os.Exit(1)
 	}
 }
 
-func run(logger log.Logger) error {
-	db, err := tsdb.OpenDBReadOnly("data", logger)
+func run(logger log.Logger, dataDir string) error {
+	if _, err := os.Stat(filepath.Join(dataDir, "wal")); err != nil {
+		return errors.Wrap(err, "checking WAL directory")
+	}
+
+	// Take the same lock as Prometheus to make sure that it isn't running
+	// and that it won't start while we are working on the data directory.
+	lockf, existed, err := fileutil.Flock(filepath.Join(dataDir, "lock"))
+	if err != nil {
+		return errors.Wrap(err, "locking data directory (is Prometheus still running?)")
+	}
+	defer func() {
+		if err := lockf.Release(); err != nil {
+			level.Warn(logger).Log("msg", "failed to release lock", "err", err)
+			return
+		}
+		if !existed {
+			os.Remove(filepath.Join(dataDir, "lock"))
+		}
+	}()
+
+	db, err := tsdb.OpenDBReadOnly(dataDir, logger)
 	if err != nil {
 		return errors.Wrap(err, "opening db")
 	}
 
-	dir, err := ioutil.TempDir("data", "tmp")
+	// The blocks need to be on the same filesystem as the data directory to be
+	// moved atomically.
+	tmpParent := dataDir
+	if dryRun {
+		tmpParent = ""
+	}
+	dir, err := ioutil.TempDir(tmpParent, "flushwal-")
 	if err != nil {
 		return errors.Wrap(err, "creating temporary dir")
 	}
@@ -37,7 +107,18 @@ func run(logger log.Logger) error {
 		os.RemoveAll(dir)
 	}()
 
-	if err := db.FlushWAL(dir); err != nil {
+	// Samples older than the most recent block aren't flushed.
+	minValidTime, err := lastBlockMaxTime(db)
+	if err != nil {
+		return err
+	}
+
+	if blockDuration > 0 {
+		err = flushAligned(logger, dataDir, dir, blockDuration, minValidTime)
+	} else {
+		err = db.FlushWAL(dir)
+	}
+	if err != nil {
 		return errors.Wrap(err, "flushing WAL")
 	}
 
@@ -45,12 +126,29 @@ func run(logger log.Logger) error {
 		return errors.Wrap(err, "closing db")
 	}
 
-	blocks, err := ioutil.ReadDir(dir)
+	blocks, err := checkBlocks(logger, dir)
 	if err != nil {
-		return errors.Wrap(err, "reading dir")
+		return err
+	}
+	if len(blocks) == 0 {
+		level.Info(logger).Log("msg", "no samples to flush")
+		return nil
+	}
+	if verify {
+		if err := verifyFlush(logger, filepath.Join(dataDir, "wal"), minValidTime, dir, blocks); err != nil {
+			return errors.Wrap(err, "verifying flushed blocks")
+		}
+	}
+	if dryRun {
+		level.Info(logger).Log("msg", "dry-run mode, leaving the data directory untouched")
+		return nil
 	}
 
-	wlog, err := wal.New(logger, nil, "data/wal", false) // do we care about compression at all
+	if transactional {
+		return installBlocks(logger, dataDir, dir, blocks)
+	}
+
+	wlog, err := wal.New(logger, nil, filepath.Join(dataDir, "wal"), false) // do we care about compression at all
 	if err != nil {
 		return errors.Wrap(err, "opening WAL")
 	}
@@ -64,13 +162,59 @@ func run(logger log.Logger) error {
 	}
 
 	for _, block := range blocks {
-		if !block.IsDir() {
-			continue
-		}
-		if err := os.Rename(filepath.Join(dir, block.Name()), filepath.Join("data", block.Name())); err != nil {
-			return errors.Wrap(err, "deleting dir")
+		if err := os.Rename(filepath.Join(dir, block), filepath.Join(dataDir, block)); err != nil {
+			return errors.Wrap(err, "moving block")
 		}
 	}
 
 	return nil
 }
+
+// lastBlockMaxTime returns the max time of the most recent block in the
+// database.
+func lastBlockMaxTime(db *tsdb.DBReadOnly) (int64, error) {
+	blockReaders, err := db.Blocks()
+	if err != nil {
+		return 0, errors.Wrap(err, "read blocks")
+	}
+	maxBlockTime := int64(math.MinInt64)
+	if len(blockReaders) > 0 {
+		maxBlockTime = blockReaders[len(blockReaders)-1].Meta().MaxTime
+	}
+	return maxBlockTime, nil
+}
+
+// checkBlocks opens all the blocks written in dir to ensure that they are
+// readable and returns their names.
+func checkBlocks(logger log.Logger, dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading dir")
+	}
+
+	var blocks []string
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		b, err := tsdb.OpenBlock(logger, filepath.Join(dir, fi.Name()), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening flushed block %s", fi.Name())
+		}
+		meta := b.Meta()
+		if err := b.Close(); err != nil {
+			return nil, errors.Wrapf(err, "closing flushed block %s", fi.Name())
+		}
+		level.Info(logger).Log(
+			"msg", "flushed block",
+			"ulid", meta.ULID,
+			"mint", meta.MinTime,
+			"maxt", meta.MaxTime,
+			"series", meta.Stats.NumSeries,
+			"samples", meta.Stats.NumSamples,
+			"chunks", meta.Stats.NumChunks,
+		)
+		blocks = append(blocks, fi.Name())
+	}
+	return blocks, nil
+}
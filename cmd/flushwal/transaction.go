@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// transaction records how to undo the changes applied to the data directory
+// so that they can be rolled back if a later step fails.
+type transaction struct {
+	logger log.Logger
+	undo   []func() error
+}
+
+func (t *transaction) rename(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	level.Debug(t.logger).Log("msg", "renamed", "from", from, "to", to)
+	t.undo = append(t.undo, func() error { return os.Rename(to, from) })
+	return nil
+}
+
+func (t *transaction) mkdir(dir string) error {
+	if err := os.Mkdir(dir, 0777); err != nil {
+		return err
+	}
+	// os.Remove fails if the directory isn't empty anymore which is what we
+	// want.
+	t.undo = append(t.undo, func() error { return os.Remove(dir) })
+	return nil
+}
+
+// rollback undoes all the recorded changes in reverse order. It keeps going
+// on errors to restore as much as possible and returns the first error.
+func (t *transaction) rollback() error {
+	var err error
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		if uerr := t.undo[i](); uerr != nil {
+			level.Error(t.logger).Log("msg", "rollback step failed", "err", uerr)
+			if err == nil {
+				err = uerr
+			}
+		}
+	}
+	t.undo = nil
+	return err
+}
+
+// installBlocks moves the WAL (including its checkpoints) to the backup
+// directory and the flushed blocks from dir into the data directory. If any
+// step fails, the data directory is restored to its original state.
+func installBlocks(logger log.Logger, dataDir, dir string, blocks []string) (err error) {
+	bdir := backupDir
+	if bdir == "" {
+		bdir = filepath.Join(dataDir, fmt.Sprintf("flushwal-backup-%d", time.Now().Unix()))
+	}
+	// A backup directory given by the user may already exist and must be
+	// left in place.
+	_, statErr := os.Stat(bdir)
+	created := os.IsNotExist(statErr)
+	if err := os.MkdirAll(bdir, 0777); err != nil {
+		return errors.Wrap(err, "creating backup dir")
+	}
+	removeBackupDir := func() {
+		if created {
+			os.Remove(bdir)
+		}
+	}
+
+	t := &transaction{logger: logger}
+	defer func() {
+		if err == nil {
+			return
+		}
+		level.Warn(logger).Log("msg", "rolling back changes", "err", err)
+		if rerr := t.rollback(); rerr != nil {
+			level.Error(logger).Log("msg", "rollback failed, the WAL backup needs to be restored manually", "backup", bdir)
+			return
+		}
+		level.Info(logger).Log("msg", "data directory restored")
+		removeBackupDir()
+	}()
+
+	walDir := filepath.Join(dataDir, "wal")
+	if err := t.rename(walDir, filepath.Join(bdir, "wal")); err != nil {
+		return errors.Wrap(err, "moving WAL to the backup dir")
+	}
+	if err := t.mkdir(walDir); err != nil {
+		return errors.Wrap(err, "creating empty WAL dir")
+	}
+	for _, block := range blocks {
+		if err := t.rename(filepath.Join(dir, block), filepath.Join(dataDir, block)); err != nil {
+			return errors.Wrap(err, "moving block")
+		}
+	}
+
+	if keepBackup {
+		level.Info(logger).Log("msg", "WAL backup kept", "backup", bdir)
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Join(bdir, "wal")); err != nil {
+		level.Warn(logger).Log("msg", "failed to remove the WAL backup", "backup", bdir, "err", err)
+		return nil
+	}
+	removeBackupDir()
+	return nil
+}
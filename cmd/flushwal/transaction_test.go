@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestInstallBlocksRollback(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		backupDir bool
+	}{
+		{name: "default backup dir"},
+		{name: "existing backup dir", backupDir: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "flushwal-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+
+			dataDir := filepath.Join(tmp, "data")
+			flushDir := filepath.Join(tmp, "flush")
+			writeFile(t, filepath.Join(dataDir, "wal", "00000000"), "segment")
+			writeFile(t, filepath.Join(dataDir, "wal", "checkpoint.000001", "00000000"), "checkpoint")
+			writeFile(t, filepath.Join(flushDir, "block1", "meta.json"), "{}")
+
+			backupDir = ""
+			if tc.backupDir {
+				backupDir = filepath.Join(tmp, "backup")
+				if err := os.Mkdir(backupDir, 0777); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defer func() { backupDir = "" }()
+
+			// The second block doesn't exist so moving it fails after the
+			// WAL and the first block have been moved.
+			err = installBlocks(log.NewNopLogger(), dataDir, flushDir, []string{"block1", "missing"})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if got := readFile(t, filepath.Join(dataDir, "wal", "00000000")); got != "segment" {
+				t.Fatalf("expected WAL segment to be restored, got %q", got)
+			}
+			if got := readFile(t, filepath.Join(dataDir, "wal", "checkpoint.000001", "00000000")); got != "checkpoint" {
+				t.Fatalf("expected WAL checkpoint to be restored, got %q", got)
+			}
+			if got := readFile(t, filepath.Join(flushDir, "block1", "meta.json")); got != "{}" {
+				t.Fatalf("expected block to be moved back, got %q", got)
+			}
+			if _, err := os.Stat(filepath.Join(dataDir, "block1")); !os.IsNotExist(err) {
+				t.Fatalf("expected block to be removed from the data dir, got %v", err)
+			}
+
+			fis, err := ioutil.ReadDir(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fis) != 1 {
+				t.Fatalf("expected only the WAL in the data dir, got %d entries", len(fis))
+			}
+			if tc.backupDir {
+				if _, err := os.Stat(backupDir); err != nil {
+					t.Fatalf("expected the existing backup dir to be kept: %v", err)
+				}
+			}
+		})
+	}
+}
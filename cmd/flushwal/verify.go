@@ -0,0 +1,296 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// maxReportedSeries is the maximum number of series with missing samples that
+// are logged individually.
+const maxReportedSeries = 20
+
+type seriesStats struct {
+	samples    int
+	mint, maxt int64
+	// Timestamp of the last sample appended from the WAL, including the
+	// deleted ones.
+	lastT int64
+}
+
+func newSeriesStats() *seriesStats {
+	return &seriesStats{mint: math.MaxInt64, maxt: math.MinInt64, lastT: math.MinInt64}
+}
+
+// readWAL calls f for every record of the last checkpoint and the following
+// segments, in the same order as the head replays them.
+func readWAL(walDir string, f func(rec []byte) error) error {
+	ranges := make([]wal.SegmentRange, 0, 2)
+	startFrom := -1
+	cpDir, idx, err := wal.LastCheckpoint(walDir)
+	switch err {
+	case nil:
+		ranges = append(ranges, wal.SegmentRange{Dir: cpDir, First: -1, Last: -1})
+		startFrom = idx + 1
+	case record.ErrNotFound:
+	default:
+		return errors.Wrap(err, "find last checkpoint")
+	}
+	ranges = append(ranges, wal.SegmentRange{Dir: walDir, First: startFrom, Last: -1})
+
+	sr, err := wal.NewSegmentsRangeReader(ranges...)
+	if err != nil {
+		return errors.Wrap(err, "open WAL")
+	}
+	defer sr.Close()
+
+	r := wal.NewReader(sr)
+	for r.Next() {
+		if err := f(r.Record()); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(r.Err(), "read WAL")
+}
+
+// walTombstones returns the deleted intervals of every series reference in
+// the WAL. Like the head, intervals ending before minValidTime are ignored.
+func walTombstones(walDir string, minValidTime int64) (map[uint64]tombstones.Intervals, error) {
+	var (
+		dec     record.Decoder
+		tstones []tombstones.Stone
+		deleted = make(map[uint64]tombstones.Intervals)
+	)
+	err := readWAL(walDir, func(rec []byte) error {
+		if dec.Type(rec) != record.Tombstones {
+			return nil
+		}
+		var err error
+		tstones, err = dec.Tombstones(rec, tstones[:0])
+		if err != nil {
+			return errors.Wrap(err, "decode tombstones")
+		}
+		for _, s := range tstones {
+			for _, itv := range s.Intervals {
+				if itv.Maxt < minValidTime {
+					continue
+				}
+				deleted[s.Ref] = deleted[s.Ref].Add(itv)
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// walStats returns the number of samples and the time range of every series
+// in the WAL, keyed by the series labels. It replays the last checkpoint and
+// the following segments the same way as the head does, ignoring samples
+// older than minValidTime as well as out-of-order and duplicated samples.
+// Samples deleted by tombstones are skipped since the blocks don't contain
+// them either.
+func walStats(walDir string, minValidTime int64) (map[string]*seriesStats, error) {
+	// The tombstones apply to all the samples of the series, including the
+	// ones appended after the deletion, hence they are read first.
+	deleted, err := walTombstones(walDir, minValidTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		dec     record.Decoder
+		series  []record.RefSeries
+		samples []record.RefSample
+		refs    = make(map[uint64]*seriesStats)
+		stats   = make(map[string]*seriesStats)
+	)
+	err = readWAL(walDir, func(rec []byte) error {
+		var err error
+		switch dec.Type(rec) {
+		case record.Series:
+			series, err = dec.Series(rec, series[:0])
+			if err != nil {
+				return errors.Wrap(err, "decode series")
+			}
+			for _, s := range series {
+				k := s.Labels.String()
+				st, ok := stats[k]
+				if !ok {
+					st = newSeriesStats()
+					stats[k] = st
+				}
+				refs[s.Ref] = st
+			}
+		case record.Samples:
+			samples, err = dec.Samples(rec, samples[:0])
+			if err != nil {
+				return errors.Wrap(err, "decode samples")
+			}
+			for _, s := range samples {
+				st, ok := refs[s.Ref]
+				if !ok || s.T < minValidTime || s.T <= st.lastT {
+					continue
+				}
+				// Deleted samples are still appended to the head and
+				// count for the detection of out-of-order samples.
+				st.lastT = s.T
+				if isDeleted(deleted[s.Ref], s.T) {
+					continue
+				}
+				st.samples++
+				if s.T < st.mint {
+					st.mint = s.T
+				}
+				st.maxt = s.T
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for k, st := range stats {
+		if st.samples == 0 {
+			delete(stats, k)
+		}
+	}
+	return stats, nil
+}
+
+func isDeleted(itvs tombstones.Intervals, t int64) bool {
+	for _, itv := range itvs {
+		if itv.InBounds(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockStats returns the number of samples and the time range of every series
+// in the blocks, keyed by the series labels.
+func blockStats(dirs []string) (map[string]*seriesStats, error) {
+	stats := make(map[string]*seriesStats)
+	for _, dir := range dirs {
+		if err := addBlockStats(stats, dir); err != nil {
+			return nil, errors.Wrapf(err, "block %s", filepath.Base(dir))
+		}
+	}
+	return stats, nil
+}
+
+func addBlockStats(stats map[string]*seriesStats, dir string) error {
+	ir, err := index.NewFileReader(filepath.Join(dir, "index"))
+	if err != nil {
+		return errors.Wrap(err, "open index")
+	}
+	defer ir.Close()
+	cr, err := chunks.NewDirReader(filepath.Join(dir, "chunks"), nil)
+	if err != nil {
+		return errors.Wrap(err, "open chunks")
+	}
+	defer cr.Close()
+
+	p, err := ir.Postings(index.AllPostingsKey())
+	if err != nil {
+		return errors.Wrap(err, "postings")
+	}
+	var (
+		lbls labels.Labels
+		chks []chunks.Meta
+	)
+	for p.Next() {
+		if err := ir.Series(p.At(), &lbls, &chks); err != nil {
+			return errors.Wrap(err, "series")
+		}
+		k := lbls.String()
+		st, ok := stats[k]
+		if !ok {
+			st = newSeriesStats()
+			stats[k] = st
+		}
+		for _, chk := range chks {
+			c, err := cr.Chunk(chk.Ref)
+			if err != nil {
+				return errors.Wrapf(err, "chunk %d of series %s", chk.Ref, k)
+			}
+			st.samples += c.NumSamples()
+			if chk.MinTime < st.mint {
+				st.mint = chk.MinTime
+			}
+			if chk.MaxTime > st.maxt {
+				st.maxt = chk.MaxTime
+			}
+		}
+	}
+	return errors.Wrap(p.Err(), "postings")
+}
+
+// verifyFlush compares the content of the WAL with the flushed blocks and
+// returns an error if any sample is missing from the blocks.
+func verifyFlush(logger log.Logger, walDir string, minValidTime int64, dir string, blocks []string) error {
+	expected, err := walStats(walDir, minValidTime)
+	if err != nil {
+		return errors.Wrap(err, "reading WAL")
+	}
+	dirs := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		dirs = append(dirs, filepath.Join(dir, b))
+	}
+	got, err := blockStats(dirs)
+	if err != nil {
+		return errors.Wrap(err, "reading blocks")
+	}
+
+	var (
+		walSamples, blockSamples int
+		missingSeries, mismatch  int
+	)
+	for k, exp := range expected {
+		walSamples += exp.samples
+		st, ok := got[k]
+		if !ok {
+			missingSeries++
+			if missingSeries+mismatch <= maxReportedSeries {
+				level.Warn(logger).Log("msg", "series missing from blocks", "series", k, "samples", exp.samples)
+			}
+			continue
+		}
+		blockSamples += st.samples
+		if st.samples < exp.samples || st.mint != exp.mint || st.maxt != exp.maxt {
+			mismatch++
+			if missingSeries+mismatch <= maxReportedSeries {
+				level.Warn(logger).Log(
+					"msg", "series mismatch",
+					"series", k,
+					"wal_samples", exp.samples, "block_samples", st.samples,
+					"wal_mint", exp.mint, "block_mint", st.mint,
+					"wal_maxt", exp.maxt, "block_maxt", st.maxt,
+				)
+			}
+		}
+	}
+
+	level.Info(logger).Log(
+		"msg", "verification summary",
+		"wal_series", len(expected),
+		"block_series", len(got),
+		"wal_samples", walSamples,
+		"block_samples", blockSamples,
+		"missing_series", missingSeries,
+		"mismatched_series", mismatch,
+	)
+	if missingSeries > 0 || mismatch > 0 {
+		return errors.Errorf("%d series missing and %d series with missing samples in the flushed blocks", missingSeries, mismatch)
+	}
+	return nil
+}
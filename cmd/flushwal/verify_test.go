@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// writeWAL creates a WAL with 2 series of 10 samples at t=1000..10000. The
+// series "deleted" has a tombstone covering [3000, 5000] and the series
+// "gone" is deleted entirely.
+func writeWAL(t *testing.T, dataDir string) {
+	t.Helper()
+	w, err := wal.New(nil, nil, filepath.Join(dataDir, "wal"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		enc     record.Encoder
+		samples []record.RefSample
+	)
+	series := []record.RefSeries{
+		{Ref: 1, Labels: labels.FromStrings("__name__", "deleted")},
+		{Ref: 2, Labels: labels.FromStrings("__name__", "gone")},
+	}
+	for i := int64(1); i <= 10; i++ {
+		samples = append(samples,
+			record.RefSample{Ref: 1, T: i * 1000, V: float64(i)},
+			record.RefSample{Ref: 2, T: i * 1000, V: float64(i)},
+		)
+	}
+	stones := []tombstones.Stone{
+		{Ref: 1, Intervals: tombstones.Intervals{{Mint: 3000, Maxt: 5000}}},
+		{Ref: 2, Intervals: tombstones.Intervals{{Mint: math.MinInt64, Maxt: math.MaxInt64}}},
+	}
+	err = w.Log(
+		enc.Series(series, nil),
+		enc.Samples(samples, nil),
+		enc.Tombstones(stones, nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALStatsTombstones(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "flushwal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	writeWAL(t, dataDir)
+
+	stats, err := walStats(filepath.Join(dataDir, "wal"), math.MinInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(stats))
+	}
+	st, ok := stats[`{__name__="deleted"}`]
+	if !ok {
+		t.Fatal("series not found")
+	}
+	if st.samples != 7 || st.mint != 1000 || st.maxt != 10000 {
+		t.Fatalf("expected 7 samples in [1000, 10000], got %d in [%d, %d]", st.samples, st.mint, st.maxt)
+	}
+}
+
+func TestVerifyFlushTombstones(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "flushwal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dataDir := filepath.Join(tmp, "data")
+	writeWAL(t, dataDir)
+
+	logger := log.NewNopLogger()
+	for _, d := range []string{"flush", "aligned"} {
+		if err := os.Mkdir(filepath.Join(tmp, d), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := tsdb.OpenDBReadOnly(dataDir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FlushWAL(filepath.Join(tmp, "flush")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := flushAligned(logger, dataDir, filepath.Join(tmp, "aligned"), 3*time.Second, math.MinInt64); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{"flush", "aligned"} {
+		dir := filepath.Join(tmp, d)
+		blocks, err := checkBlocks(logger, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) == 0 {
+			t.Fatalf("%s: no block flushed", d)
+		}
+		if err := verifyFlush(logger, filepath.Join(dataDir, "wal"), math.MinInt64, dir, blocks); err != nil {
+			t.Fatalf("%s: %v", d, err)
+		}
+	}
+}
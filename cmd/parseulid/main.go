@@ -1,15 +1,197 @@
+// Utility program to decode and generate ULIDs such as the TSDB block names.
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
 )
 
+var (
+	help     bool
+	generate bool
+	output   string
+	scanDir  string
+)
+
+func init() {
+	flag.BoolVar(&help, "help", false, "Show help")
+	flag.BoolVar(&generate, "generate", false, "Generate ULIDs for the given timestamps (RFC3339 or milliseconds, default: now) instead of parsing ULIDs")
+	flag.StringVar(&output, "output", "text", "Output format (text or json)")
+	flag.StringVar(&scanDir, "scan", "", "Scan a TSDB data directory and annotate the ULID-named block directories with their meta.json")
+}
+
+// result is the outcome of parsing or generating one ULID.
+type result struct {
+	Input     string `json:"input,omitempty"`
+	ULID      string `json:"ulid,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Timestamp uint64 `json:"timestamp,omitempty"`
+	Entropy   string `json:"entropy,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newResult(input string, id ulid.ULID) result {
+	return result{
+		Input:     input,
+		ULID:      id.String(),
+		Time:      ulid.Time(id.Time()).UTC().Format(time.RFC3339Nano),
+		Timestamp: id.Time(),
+		Entropy:   fmt.Sprintf("%x", id.Entropy()),
+	}
+}
+
+func parse(s string) result {
+	id, err := ulid.ParseStrict(s)
+	if err != nil {
+		return result{Input: s, Error: err.Error()}
+	}
+	return newResult(s, id)
+}
+
+var entropy = ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
+
+func generateULID(s string) result {
+	t, err := parseTime(s)
+	if err != nil {
+		return result{Input: s, Error: err.Error()}
+	}
+	id, err := ulid.New(ulid.Timestamp(t), entropy)
+	if err != nil {
+		return result{Input: s, Error: err.Error()}
+	}
+	return newResult(s, id)
+}
+
+// parseTime parses either a RFC3339 date or a timestamp in milliseconds.
+func parseTime(s string) (time.Time, error) {
+	if s == "now" {
+		return time.Now(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q: expecting RFC3339 or milliseconds", s)
+	}
+	return time.Unix(ms/1e3, (ms%1e3)*1e6), nil
+}
+
+type resultWriter func(result) error
+
+func newResultWriter(w io.Writer, format string) (resultWriter, error) {
+	switch format {
+	case "text":
+		return func(r result) error {
+			var err error
+			if r.Error != "" {
+				_, err = fmt.Fprintf(w, "%s: error: %s\n", r.Input, r.Error)
+			} else {
+				_, err = fmt.Fprintf(w, "%s time: %s timestamp: %d entropy: %s\n", r.ULID, r.Time, r.Timestamp, r.Entropy)
+			}
+			return err
+		}, nil
+	case "json":
+		enc := json.NewEncoder(w)
+		return func(r result) error { return enc.Encode(r) }, nil
+	}
+	return nil, errors.Errorf("invalid output format %q", format)
+}
+
 func main() {
-	ul := ulid.MustParseStrict(os.Args[1])
-	t := int64(ul.Time())
-	fmt.Println("time:", time.Unix(t/1e3, (t%1e3)*1e6).UTC(), "entropy:", fmt.Sprintf("%x", ul.Entropy()))
+	flag.Parse()
+	if help {
+		fmt.Fprintln(os.Stderr, "Usage: parseulid [flags] [ULID or timestamp...]")
+		fmt.Fprintln(os.Stderr, "       parseulid --scan <data dir> [--output json]")
+		fmt.Fprintln(os.Stderr, "Decodes ULIDs from the arguments or from stdin (one per line).")
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	if scanDir != "" {
+		if err := scan(scanDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	write, err := newResultWriter(os.Stdout, output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	process := parse
+	if generate {
+		process = generateULID
+	}
+
+	var failed bool
+	handle := func(s string) {
+		r := process(s)
+		if r.Error != "" {
+			failed = true
+		}
+		if err := write(r); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	switch {
+	case flag.NArg() > 0:
+		for _, arg := range flag.Args() {
+			handle(arg)
+		}
+	case generate:
+		handle("now")
+	default:
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			handle(line)
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading stdin:", err)
+			os.Exit(1)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func scan(dir string) error {
+	if output != "text" && output != "json" {
+		return errors.Errorf("invalid output format %q", output)
+	}
+	blocks, err := scanBlocks(dir)
+	if err != nil {
+		return errors.Wrapf(err, "scanning %s", dir)
+	}
+	if err := printBlocks(os.Stdout, blocks); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if b.Error != "" || len(b.Warnings) > 0 {
+			return errors.New("found invalid blocks")
+		}
+	}
+	return nil
 }
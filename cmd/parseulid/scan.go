@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// blockInfo describes a ULID-named directory found in a data directory.
+type blockInfo struct {
+	Dir      string          `json:"dir"`
+	ULID     string          `json:"ulid"`
+	Created  string          `json:"created"`
+	Meta     *tsdb.BlockMeta `json:"meta,omitempty"`
+	Warnings []string        `json:"warnings,omitempty"`
+	Error    string          `json:"error,omitempty"`
+
+	id ulid.ULID
+}
+
+func (b *blockInfo) warnf(format string, args ...interface{}) {
+	b.Warnings = append(b.Warnings, fmt.Sprintf(format, args...))
+}
+
+// scanBlocks walks the directory and returns all the ULID-named directories
+// annotated with the content of their meta.json file.
+func scanBlocks(dir string) ([]*blockInfo, error) {
+	var blocks []*blockInfo
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		id, err := ulid.ParseStrict(fi.Name())
+		if err != nil {
+			return nil
+		}
+
+		b := &blockInfo{
+			Dir:     path,
+			ULID:    id.String(),
+			Created: formatTime(int64(id.Time())),
+			id:      id,
+		}
+		blocks = append(blocks, b)
+		meta, err := readMeta(path)
+		if err != nil {
+			b.Error = err.Error()
+		} else {
+			b.Meta = meta
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	checkBlocks(blocks, time.Now())
+	return blocks, nil
+}
+
+func readMeta(dir string) (*tsdb.BlockMeta, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+	var meta tsdb.BlockMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, errors.Wrap(err, "parsing meta.json")
+	}
+	return &meta, nil
+}
+
+// checkBlocks flags the blocks created in the future, the blocks whose
+// meta.json doesn't match the directory and the blocks of the same parent
+// directory with overlapping time ranges.
+func checkBlocks(blocks []*blockInfo, now time.Time) {
+	withMeta := make(map[string][]*blockInfo)
+	for _, b := range blocks {
+		if ulid.Time(b.id.Time()).After(now) {
+			b.warnf("created in the future")
+		}
+		if b.Meta == nil {
+			continue
+		}
+		if b.Meta.ULID != b.id {
+			b.warnf("meta.json has ULID %s", b.Meta.ULID)
+		}
+		if b.Meta.MinTime >= b.Meta.MaxTime {
+			b.warnf("empty time range")
+		}
+		parent := filepath.Dir(b.Dir)
+		withMeta[parent] = append(withMeta[parent], b)
+	}
+
+	for _, bs := range withMeta {
+		sort.Slice(bs, func(i, j int) bool { return bs[i].Meta.MinTime < bs[j].Meta.MinTime })
+		for i, a := range bs {
+			for _, b := range bs[i+1:] {
+				if b.Meta.MinTime >= a.Meta.MaxTime {
+					break
+				}
+				a.warnf("overlaps with %s", b.ULID)
+				b.warnf("overlaps with %s", a.ULID)
+			}
+		}
+	}
+}
+
+func formatTime(ms int64) string {
+	return time.Unix(ms/1e3, (ms%1e3)*1e6).UTC().Format(time.RFC3339)
+}
+
+func printBlocks(w io.Writer, blocks []*blockInfo) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		for _, b := range blocks {
+			if err := enc.Encode(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ULID\tCREATED\tMIN TIME\tMAX TIME\tLEVEL\tSERIES\tSAMPLES\tCHUNKS\tPARENTS\tWARNINGS")
+	for _, b := range blocks {
+		if b.Meta == nil {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\t-\t-\t-\t-\terror: %s\n", b.ULID, b.Created, b.Error)
+			continue
+		}
+		parents := make([]string, 0, len(b.Meta.Compaction.Parents))
+		for _, p := range b.Meta.Compaction.Parents {
+			parents = append(parents, p.ULID.String())
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			b.ULID, b.Created, formatTime(b.Meta.MinTime), formatTime(b.Meta.MaxTime),
+			b.Meta.Compaction.Level, b.Meta.Stats.NumSeries, b.Meta.Stats.NumSamples, b.Meta.Stats.NumChunks,
+			orDash(strings.Join(parents, ",")), orDash(strings.Join(b.Warnings, "; ")),
+		)
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The parser of execution traces lives in an internal package of the Go
+// distribution and the format changes between Go versions. Instead the
+// events are read from the output of "go tool trace -d=parsed" (Go 1.23 or
+// later) which understands the traces produced by the same and older Go
+// versions.
+
+// event is a task or region event of the trace.
+type event struct {
+	kind string
+	g    int64
+	time int64
+	// Task ID for task events, ID of the enclosing task for region events.
+	task   uint64
+	parent uint64
+	name   string
+}
+
+var (
+	eventRe  = regexp.MustCompile(`^M=\S+ P=\S+ G=(-?\d+) (TaskBegin|TaskEnd|RegionBegin|RegionEnd) Time=(\d+) `)
+	idRe     = regexp.MustCompile(` (ID|Task)=(\d+)`)
+	parentRe = regexp.MustCompile(` Parent=(\d+)`)
+	typeRe   = regexp.MustCompile(` Type=("(?:[^"\\]|\\.)*")`)
+)
+
+func parseEvent(line string) (*event, bool, error) {
+	m := eventRe.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false, nil
+	}
+	e := &event{kind: m[2]}
+	e.g, _ = strconv.ParseInt(m[1], 10, 64)
+	e.time, _ = strconv.ParseInt(m[3], 10, 64)
+	if m := idRe.FindStringSubmatch(line); m != nil {
+		e.task, _ = strconv.ParseUint(m[2], 10, 64)
+	}
+	if m := parentRe.FindStringSubmatch(line); m != nil {
+		e.parent, _ = strconv.ParseUint(m[1], 10, 64)
+	}
+	m = typeRe.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false, errors.Errorf("missing type: %q", line)
+	}
+	name, err := strconv.Unquote(m[1])
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid type: %q", line)
+	}
+	e.name = name
+	return e, true, nil
+}
+
+// spanStats aggregates the durations of the tasks or regions with the same
+// path.
+type spanStats struct {
+	Kind      string          `json:"kind"`
+	Path      string          `json:"path"`
+	Depth     int             `json:"depth"`
+	durations []time.Duration // sorted before reporting
+}
+
+func (s *spanStats) total() time.Duration {
+	var t time.Duration
+	for _, d := range s.durations {
+		t += d
+	}
+	return t
+}
+
+// quantile returns the q-quantile of the durations using the nearest-rank
+// method.
+func (s *spanStats) quantile(q float64) time.Duration {
+	if len(s.durations) == 0 {
+		return 0
+	}
+	i := int(q*float64(len(s.durations))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s.durations) {
+		i = len(s.durations) - 1
+	}
+	return s.durations[i]
+}
+
+type openSpan struct {
+	path  string
+	start int64
+}
+
+// analyzer pairs the begin and end events. Tasks are nested by parent task
+// and regions by task and by goroutine.
+type analyzer struct {
+	tasks   map[uint64]*openSpan
+	names   map[uint64]string // path of the tasks, kept after their end for the children
+	regions map[int64][]*openSpan
+	stats   map[string]*spanStats
+	// Number of events without a matching begin or end, typically because
+	// they happened before or after the capture.
+	unmatched int
+	// Number of task and region events.
+	events int
+}
+
+func newAnalyzer() *analyzer {
+	return &analyzer{
+		tasks:   make(map[uint64]*openSpan),
+		names:   make(map[uint64]string),
+		regions: make(map[int64][]*openSpan),
+		stats:   make(map[string]*spanStats),
+	}
+}
+
+// noTask is the parent of the top-level tasks, 0 is the background task.
+const noTask = ^uint64(0)
+
+func (a *analyzer) taskPath(id uint64) string {
+	if id == 0 || id == noTask {
+		return ""
+	}
+	if p, ok := a.names[id]; ok {
+		return p
+	}
+	return "?"
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+func (a *analyzer) record(kind, path string, d int64) {
+	key := kind + " " + path
+	s, ok := a.stats[key]
+	if !ok {
+		s = &spanStats{Kind: kind, Path: path, Depth: strings.Count(path, "/")}
+		a.stats[key] = s
+	}
+	s.durations = append(s.durations, time.Duration(d))
+}
+
+func (a *analyzer) add(e *event) {
+	a.events++
+	switch e.kind {
+	case "TaskBegin":
+		path := joinPath(a.taskPath(e.parent), e.name)
+		a.names[e.task] = path
+		a.tasks[e.task] = &openSpan{path: path, start: e.time}
+	case "TaskEnd":
+		t, ok := a.tasks[e.task]
+		if !ok {
+			a.unmatched++
+			return
+		}
+		delete(a.tasks, e.task)
+		a.record("task", t.path, e.time-t.start)
+	case "RegionBegin":
+		parent := a.taskPath(e.task)
+		if stack := a.regions[e.g]; len(stack) > 0 {
+			parent = stack[len(stack)-1].path
+		}
+		a.regions[e.g] = append(a.regions[e.g], &openSpan{path: joinPath(parent, e.name), start: e.time})
+	case "RegionEnd":
+		stack := a.regions[e.g]
+		if len(stack) == 0 {
+			a.unmatched++
+			return
+		}
+		r := stack[len(stack)-1]
+		a.regions[e.g] = stack[:len(stack)-1]
+		a.record("region", r.path, e.time-r.start)
+	}
+}
+
+func (a *analyzer) results() []*spanStats {
+	a.unmatched += len(a.tasks)
+	for _, stack := range a.regions {
+		a.unmatched += len(stack)
+	}
+	res := make([]*spanStats, 0, len(a.stats))
+	for _, s := range a.stats {
+		sort.Slice(s.durations, func(i, j int) bool { return s.durations[i] < s.durations[j] })
+		res = append(res, s)
+	}
+	// Sorting by path lists the children right after their parent.
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Path != res[j].Path {
+			return res[i].Path < res[j].Path
+		}
+		return res[i].Kind > res[j].Kind
+	})
+	return res
+}
+
+// readEvents feeds the task and region events of the "go tool trace
+// -d=parsed" output to a new analyzer.
+func readEvents(r io.Reader) (*analyzer, error) {
+	a := newAnalyzer()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		e, ok, err := parseEvent(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			a.add(e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func analyzeTrace(goBin, file string) ([]*spanStats, int, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(goBin, "tool", "trace", "-d=parsed", file)
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, 0, errors.Wrap(err, "running go tool trace")
+	}
+
+	a, err := readEvents(out)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, 0, err
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, 0, errors.Errorf("go tool trace: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// An unexpected output format of "go tool trace" shouldn't be mistaken
+	// for a trace without annotations.
+	if a.events == 0 {
+		return nil, 0, errors.Errorf("no task or region events found in %s (is %s older than Go 1.23 or its output format changed?)", file, goBin)
+	}
+	res := a.results()
+	return res, a.unmatched, nil
+}
+
+func printSpanStats(w io.Writer, stats []*spanStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "KIND\tPATH\tCOUNT\tTOTAL\tMIN\tMAX\tP50\tP90\tP99")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%s%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Kind, strings.Repeat("  ", s.Depth), s.Path, len(s.durations),
+			round(s.total()), round(s.quantile(0)), round(s.quantile(1)),
+			round(s.quantile(0.5)), round(s.quantile(0.9)), round(s.quantile(0.99)))
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+type jsonSpanStats struct {
+	*spanStats
+	Count int     `json:"count"`
+	Total float64 `json:"total_seconds"`
+	Min   float64 `json:"min_seconds"`
+	Max   float64 `json:"max_seconds"`
+	P50   float64 `json:"p50_seconds"`
+	P90   float64 `json:"p90_seconds"`
+	P99   float64 `json:"p99_seconds"`
+}
+
+func printSpanStatsJSON(w io.Writer, stats []*spanStats) error {
+	res := make([]jsonSpanStats, 0, len(stats))
+	for _, s := range stats {
+		res = append(res, jsonSpanStats{
+			spanStats: s,
+			Count:     len(s.durations),
+			Total:     s.total().Seconds(),
+			Min:       s.quantile(0).Seconds(),
+			Max:       s.quantile(1).Seconds(),
+			P50:       s.quantile(0.5).Seconds(),
+			P90:       s.quantile(0.9).Seconds(),
+			P99:       s.quantile(0.99).Seconds(),
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+func analyze(file string) error {
+	if output != "text" && output != "json" {
+		return errors.Errorf("invalid output format %q", output)
+	}
+	stats, unmatched, err := analyzeTrace(goBin, file)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		return printSpanStatsJSON(os.Stdout, stats)
+	}
+	printSpanStats(os.Stdout, stats)
+	if unmatched > 0 {
+		fmt.Fprintf(os.Stderr, "%d task or region events without a matching begin or end were ignored.\n", unmatched)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Output of "go tool trace -d=parsed" for the example program, completed
+// with a nested task and regions on another goroutine.
+const parsedTrace = `M=-1 P=-1 G=-1 Sync Time=1212932416576 N=1 Trace=1212932423616 Mono=1212932423601 Wall=2026-10-18T21:53:42.998476875Z
+M=9909 P=0 G=1 TaskBegin Time=1000 ID=1 Parent=18446744073709551615 Type="run"
+	github.com/simonpasquier/sandbox/pkg/tracing.StartTask @ 0x52fa34
+M=9909 P=0 G=1 RegionBegin Time=2000 Task=1 Type="step1"
+M=9909 P=0 G=1 RegionBegin Time=3000 Task=1 Type="step1.1"
+	github.com/simonpasquier/sandbox/pkg/tracing.WithRegion @ 0x52fb27
+M=9909 P=0 G=1 StateTransition Time=3500 GoID=1 Running->Waiting Reason="sleep"
+M=9909 P=0 G=1 RegionEnd Time=8000 Task=1 Type="step1.1"
+M=9909 P=0 G=1 RegionEnd Time=9000 Task=1 Type="step1"
+M=9909 P=0 G=1 TaskBegin Time=9500 ID=2 Parent=1 Type="child task"
+M=9909 P=1 G=7 RegionBegin Time=9600 Task=2 Type="step2"
+M=9909 P=1 G=7 RegionEnd Time=10600 Task=2 Type="step2"
+M=9909 P=1 G=7 RegionBegin Time=10700 Task=2 Type="step2"
+M=9909 P=1 G=7 RegionEnd Time=13700 Task=2 Type="step2"
+M=9909 P=1 G=7 TaskEnd Time=14000 ID=2 Parent=1 Type="child task"
+M=9909 P=0 G=1 RegionEnd Time=14500 Task=1 Type="started before the capture"
+M=9909 P=0 G=1 TaskEnd Time=15000 ID=1 Parent=18446744073709551615 Type="run"
+`
+
+func TestParseEvent(t *testing.T) {
+	e, ok, err := parseEvent(`M=9909 P=0 G=12 RegionBegin Time=1213032680896 Task=3 Type="quoted \"name\""`)
+	if err != nil || !ok {
+		t.Fatalf("expected event, got ok=%v err=%v", ok, err)
+	}
+	if e.kind != "RegionBegin" || e.g != 12 || e.time != 1213032680896 || e.task != 3 || e.name != `quoted "name"` {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+
+	if _, ok, err := parseEvent(`M=9909 P=0 G=1 StateTransition Time=3500 GoID=1 Running->Waiting Reason=""`); ok || err != nil {
+		t.Fatalf("expected other events to be skipped, got ok=%v err=%v", ok, err)
+	}
+	if _, _, err := parseEvent(`M=9909 P=0 G=1 TaskBegin Time=1000 ID=1 Parent=0`); err == nil {
+		t.Fatal("expected an error for an event without type")
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	a, err := readEvents(strings.NewReader(parsedTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.events != 13 {
+		t.Fatalf("expected 13 events, got %d", a.events)
+	}
+
+	type result struct {
+		kind      string
+		path      string
+		depth     int
+		durations []time.Duration
+	}
+	exp := []result{
+		{"task", "run", 0, []time.Duration{14000}},
+		{"task", "run/child task", 1, []time.Duration{4500}},
+		{"region", "run/child task/step2", 2, []time.Duration{1000, 3000}},
+		{"region", "run/step1", 1, []time.Duration{7000}},
+		{"region", "run/step1/step1.1", 2, []time.Duration{5000}},
+	}
+	got := a.results()
+	if len(got) != len(exp) {
+		t.Fatalf("expected %d results, got %d", len(exp), len(got))
+	}
+	for i, s := range got {
+		e := exp[i]
+		if s.Kind != e.kind || s.Path != e.path || s.Depth != e.depth {
+			t.Fatalf("result %d: expected %s %q (depth %d), got %s %q (depth %d)", i, e.kind, e.path, e.depth, s.Kind, s.Path, s.Depth)
+		}
+		if len(s.durations) != len(e.durations) {
+			t.Fatalf("%s: expected %v, got %v", s.Path, e.durations, s.durations)
+		}
+		for j := range s.durations {
+			if s.durations[j] != e.durations[j] {
+				t.Fatalf("%s: expected %v, got %v", s.Path, e.durations, s.durations)
+			}
+		}
+	}
+
+	step2 := got[2]
+	if step2.total() != 4000 || step2.quantile(0) != 1000 || step2.quantile(1) != 3000 || step2.quantile(0.5) != 1000 {
+		t.Fatalf("unexpected stats for step2: total=%v min=%v max=%v p50=%v", step2.total(), step2.quantile(0), step2.quantile(1), step2.quantile(0.5))
+	}
+	if a.unmatched != 1 {
+		t.Fatalf("expected 1 unmatched event, got %d", a.unmatched)
+	}
+}
+
+func TestAnalyzeTraceNoEvents(t *testing.T) {
+	// "true" outputs nothing like a "go tool trace" with an unknown output
+	// format.
+	if _, _, err := analyzeTrace("true", "trace.out"); err == nil {
+		t.Fatal("expected an error when no events are found")
+	}
+}
@@ -2,17 +2,49 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"runtime/trace"
 	"time"
+
+	"github.com/simonpasquier/sandbox/pkg/tracing"
+)
+
+var (
+	help        bool
+	analyzeFile string
+	output      string
+	goBin       string
 )
 
-// Example demonstrates the use of the trace package to trace
+func init() {
+	flag.BoolVar(&help, "help", false, "Show help")
+	flag.StringVar(&analyzeFile, "analyze", "", "Summarize the tasks and regions of an execution trace file instead of running the example")
+	flag.StringVar(&output, "output", "text", "Output format of the summary (text or json)")
+	flag.StringVar(&goBin, "go", "go", "Go command used to parse the execution trace (Go 1.23 or later)")
+}
+
+// Example demonstrates the use of the tracing package to trace
 // the execution of a Go program. The trace output will be
 // written to the file trace.out
 func main() {
+	flag.Parse()
+	if help {
+		fmt.Fprintln(os.Stderr, "Usage: tracetest [--analyze <trace file>]")
+		fmt.Fprintln(os.Stderr, "Writes the execution trace of an example program to trace.out or summarizes the tasks and regions of a trace.")
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	if analyzeFile != "" {
+		if err := analyze(analyzeFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	f, err := os.Create("trace.out")
 	if err != nil {
 		log.Fatalf("failed to create trace output file: %v", err)
@@ -23,10 +55,11 @@ func main() {
 		}
 	}()
 
-	if err := trace.Start(f); err != nil {
+	stop, err := tracing.Start(f)
+	if err != nil {
 		log.Fatalf("failed to start trace: %v", err)
 	}
-	defer trace.Stop()
+	defer stop()
 
 	run()
 }
@@ -34,22 +67,22 @@ func main() {
 func run() {
 	fmt.Println("this function will be traced")
 	ctx := context.Background()
-	ctx, trace := trace.NewTask(ctx, "run")
-	defer trace.End()
+	ctx, end := tracing.StartTask(ctx, "run")
+	defer end()
 	step1(ctx)
 	step2(ctx)
 }
 
 func step1(ctx context.Context) {
-	defer trace.StartRegion(ctx, "step1").End()
+	defer tracing.StartRegion(ctx, "step1").End()
 	time.Sleep(100 * time.Millisecond)
-	trace.WithRegion(ctx, "step1.1", func() {
+	tracing.WithRegion(ctx, "step1.1", func() {
 		time.Sleep(500 * time.Millisecond)
 	})
 }
 
 func step2(ctx context.Context) {
-	trace.WithRegion(ctx, "step2", func() {
+	tracing.WithRegion(ctx, "step2", func() {
 		time.Sleep(200 * time.Millisecond)
 	})
 }
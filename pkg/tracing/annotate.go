@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"runtime/trace"
+)
+
+// The functions below are thin wrappers of the runtime/trace annotations so
+// that programs don't need to import both packages. They are cheap when no
+// trace is being captured.
+
+// StartTask creates a task, which usually spans one logical operation such
+// as an HTTP request. The returned function ends the task.
+func StartTask(ctx context.Context, name string) (context.Context, func()) {
+	ctx, task := trace.NewTask(ctx, name)
+	return ctx, task.End
+}
+
+// StartRegion starts a region of the current goroutine. The returned region
+// must be ended from the same goroutine:
+//
+//	defer tracing.StartRegion(ctx, "render").End()
+func StartRegion(ctx context.Context, name string) *trace.Region {
+	return trace.StartRegion(ctx, name)
+}
+
+// WithRegion runs f in a region.
+func WithRegion(ctx context.Context, name string, f func()) {
+	trace.WithRegion(ctx, name, f)
+}
+
+// Logf emits a log message attached to the task of the context.
+func Logf(ctx context.Context, category string, format string, args ...interface{}) {
+	if !trace.IsEnabled() {
+		return
+	}
+	trace.Log(ctx, category, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,181 @@
+// Package tracing helps capturing Go execution traces (see runtime/trace)
+// from long-running programs and annotating their work with tasks and
+// regions.
+//
+// Only one execution trace can be captured at a time by a process. Traces
+// can be triggered with an HTTP request:
+//
+//	http.Handle("/-/trace", tracing.Handler())
+//
+//	$ curl -o trace.out 'http://localhost:8080/-/trace?duration=5s'
+//
+// or with a signal:
+//
+//	stop := tracing.NotifySignal("/tmp", 5*time.Second, logger, syscall.SIGUSR1)
+//	defer stop()
+//
+// The captured traces can be inspected with "go tool trace".
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/trace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDuration is the duration of the captures when none is given.
+const DefaultDuration = 5 * time.Second
+
+// ErrRunning is returned when a trace is already being captured.
+var ErrRunning = errors.New("execution trace already running")
+
+var mtx sync.Mutex
+
+// Start starts writing the execution trace of the process to w. The returned
+// function stops the trace.
+func Start(w io.Writer) (func(), error) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	if trace.IsEnabled() {
+		return nil, ErrRunning
+	}
+	if err := trace.Start(w); err != nil {
+		return nil, errors.Wrap(err, "starting trace")
+	}
+	var once sync.Once
+	return func() { once.Do(trace.Stop) }, nil
+}
+
+// Capture writes the execution trace of the process to w for the given
+// duration or until the context is canceled.
+func Capture(ctx context.Context, w io.Writer, d time.Duration) error {
+	stop, err := Start(w)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CaptureFile writes the execution trace to a new file in dir and returns
+// its path.
+func CaptureFile(ctx context.Context, dir string, d time.Duration) (string, error) {
+	name := filepath.Join(dir, fmt.Sprintf("trace-%s.out", time.Now().UTC().Format("20060102T150405Z")))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	err = Capture(ctx, f, d)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// Handler returns an HTTP handler streaming the execution trace. The
+// duration is set by the "duration" parameter (e.g. "10s") or the "seconds"
+// parameter like the net/http/pprof handler. The server's write timeout
+// must be longer than the duration.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, err := parseDuration(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if trace.IsEnabled() {
+			http.Error(w, ErrRunning.Error(), http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="trace.out"`)
+		if err := Capture(r.Context(), w, d); err != nil {
+			if err == ErrRunning {
+				w.Header().Del("Content-Disposition")
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			// The response has probably been written partially already.
+			log.Println("Error capturing execution trace:", err)
+		}
+	})
+}
+
+func parseDuration(r *http.Request) (time.Duration, error) {
+	if s := r.FormValue("duration"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return 0, errors.Errorf("invalid duration %q", s)
+		}
+		return d, nil
+	}
+	if s := r.FormValue("seconds"); s != "" {
+		sec, err := strconv.ParseFloat(s, 64)
+		if err != nil || sec <= 0 {
+			return 0, errors.Errorf("invalid seconds %q", s)
+		}
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+	return DefaultDuration, nil
+}
+
+// NotifySignal captures an execution trace into dir for the given duration
+// every time that the process receives one of the signals. The returned
+// function stops listening for the signals.
+func NotifySignal(dir string, d time.Duration, logger *log.Logger, sigs ...os.Signal) func() {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-c:
+				logger.Printf("Received %s, capturing execution trace for %s", sig, d)
+				name, err := CaptureFile(ctx, dir, d)
+				if err != nil {
+					logger.Println("Error capturing execution trace:", err)
+					continue
+				}
+				logger.Println("Execution trace written to", name)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(c)
+		cancel()
+		<-done
+	}
+}